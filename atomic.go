@@ -0,0 +1,266 @@
+package bsftp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PartialUploads decides the fate of an atomic upload whose session ends
+// before it is closed.
+type PartialUploads int
+
+const (
+	DiscardPartialUploads PartialUploads = iota
+	// KeepPartialUploads leaves the temporary file in place, to be picked up
+	// again by the next upload to the same file that does not truncate it.
+	// Only one upload at a time may write to it; any other upload to the same
+	// file meanwhile gets a temporary file of its own.
+	KeepPartialUploads
+)
+
+// AtomicUploads makes files opened for writing be written to a hidden
+// temporary file alongside them, which replaces the real file only once the
+// upload is successfully closed.
+func AtomicUploads(partial PartialUploads) ServerOption {
+	return func(s *Server) error {
+		s.atomicUploads = true
+		s.partialUploads = partial
+		return nil
+	}
+}
+
+// partialName names the temporary file an upload is written to. Unless it is
+// to be resumed, each upload gets one of its own.
+func partialName(target string, resumable bool) string {
+	dir, base := filepath.Split(target)
+	if resumable {
+		return filepath.Join(dir, "."+base+".partial")
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	return filepath.Join(dir, "."+base+"."+hex.EncodeToString(suffix)+".partial")
+}
+
+// claimLockName names the file marking a resumable temporary file as being
+// written to.
+func claimLockName(partial string) string {
+	return partial + ".lock"
+}
+
+// unownedClaimExpiry is how long a claim may go without naming its owner
+// before it is taken for one whose owner died as it was being made.
+const unownedClaimExpiry = time.Minute
+
+// claimPartial claims the resumable temporary file of an upload, reporting
+// false if partial uploads are not kept or another upload holds it. Being
+// created exclusively, the claim holds across sessions and processes; it
+// names the process holding it, so that one left behind by a process that
+// has since died on this host can be broken.
+func (s *Server) claimPartial(target string) bool {
+	if s.partialUploads != KeepPartialUploads {
+		return false
+	}
+
+	name := claimLockName(partialName(target, true))
+	if err := createClaim(name); err == nil {
+		return true
+	} else if !os.IsExist(err) || !breakStaleClaim(name) {
+		return false
+	}
+
+	return createClaim(name) == nil
+}
+
+func createClaim(name string) error {
+	lock, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	_, err = fmt.Fprintf(lock, "%s %d\n", host, os.Getpid())
+	if closeErr := lock.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(name)
+	}
+
+	return err
+}
+
+// breakStaleClaim removes a claim whose owner is gone, reporting whether it
+// did. The claim is moved aside before it is removed, so that should another
+// upload have broken it first and claimed the file since, the fresh claim is
+// put back rather than lost.
+func breakStaleClaim(name string) bool {
+	if !staleClaim(name) {
+		return false
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	aside := name + "." + hex.EncodeToString(suffix)
+	if err := os.Rename(name, aside); err != nil {
+		return false
+	} else if !staleClaim(aside) {
+		os.Rename(aside, name)
+		return false
+	}
+
+	os.Remove(aside)
+	return true
+}
+
+// staleClaim reports whether a claim was left behind by a process no longer
+// running on this host. Claims made on other hosts sharing the filesystem
+// cannot be told apart from live ones, and are never stale.
+func staleClaim(name string) bool {
+	owner, err := os.ReadFile(name)
+	if err != nil {
+		return false
+	}
+
+	var host string
+	var pid int
+	if _, err := fmt.Sscanf(string(owner), "%s %d", &host, &pid); err != nil || pid <= 0 {
+		fi, err := os.Stat(name)
+		return err == nil && time.Since(fi.ModTime()) > unownedClaimExpiry
+	}
+
+	hostname, err := os.Hostname()
+	return err == nil && host == hostname && !processExists(pid)
+}
+
+// releasePartial gives up the claim on a temporary file, if it was claimed.
+func (s *Server) releasePartial(name, target string) {
+	if s.partialUploads == KeepPartialUploads && name == partialName(target, true) {
+		os.Remove(claimLockName(name))
+	}
+}
+
+// preparePartial picks the temporary file to write an upload to. When the
+// upload neither truncates nor resumes, the temporary file starts out as a
+// copy of the file it will replace.
+func (s *Server) preparePartial(target string, pflags uint32) (partial string, err error) {
+	claimed := s.claimPartial(target)
+	partial = partialName(target, claimed)
+	defer func() {
+		if err != nil && claimed {
+			os.Remove(claimLockName(partial))
+		}
+	}()
+
+	if pflags&SSH_FXF_EXCL != 0 {
+		if _, err := os.Lstat(target); err == nil {
+			return "", fileExistsError
+		}
+	}
+
+	// the temporary file is always created, but the real one must exist
+	// unless the client asked for it to be
+	if pflags&SSH_FXF_CREAT == 0 {
+		if _, err := os.Stat(target); err != nil {
+			return "", err
+		}
+	}
+
+	if pflags&SSH_FXF_TRUNC != 0 {
+		return partial, nil
+	} else if _, err := os.Lstat(partial); err == nil {
+		return partial, nil
+	}
+
+	src, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return partial, nil
+	} else if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return "", err
+	} else if err := s.chargeQuota(uint64(fi.Size()), 1); err != nil {
+		return "", err
+	}
+
+	dst, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		s.refundQuota(uint64(fi.Size()), 1)
+		return "", err
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		s.discardPartial(partial)
+		return "", err
+	}
+
+	return partial, nil
+}
+
+// commitUpload closes an atomic upload and moves it into place. OnUpload is
+// called before the move, so an upload it vetoes never becomes visible and is
+// discarded.
+func (s *Server) commitUpload(f *openFile) error {
+	defer s.releasePartial(f.Name(), f.Target)
+
+	var event FileEvent
+	var err error
+	if s.hooks.OnUpload != nil {
+		event, err = s.uploadEvent(f)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		s.abandonPartial(f.Name())
+		return err
+	}
+
+	if err := s.runHook(s.hooks.OnUpload, event); err != nil {
+		s.discardPartial(f.Name())
+		return err
+	}
+
+	replaced, statErr := os.Lstat(f.Target)
+	if err := os.Rename(f.Name(), f.Target); err != nil {
+		s.abandonPartial(f.Name())
+		return err
+	}
+
+	if statErr == nil {
+		s.refundQuota(uint64(replaced.Size()), 1)
+	}
+
+	return nil
+}
+
+// abandonPartial disposes of an upload that will never be committed, unless
+// it is to be kept for resuming.
+func (s *Server) abandonPartial(name string) {
+	if s.partialUploads != KeepPartialUploads {
+		s.discardPartial(name)
+	}
+}
+
+func (s *Server) discardPartial(name string) {
+	if fi, err := removeFile(name); err == nil {
+		s.refundQuota(uint64(fi.Size()), 1)
+	}
+}
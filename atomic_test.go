@@ -0,0 +1,181 @@
+package bsftp
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func openUpload(c testConn, id uint32, name string) string {
+	return c.handle(&sshFXPOpenPacket{ID: id, Filename: name, PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT | SSH_FXF_TRUNC})
+}
+
+func writeUpload(c testConn, id uint32, handle, data string) {
+	c.t.Helper()
+
+	if code := c.status(&sshFXPWritePacket{ID: id, Handle: handle, Data: []byte(data)}); code != SSH_FX_OK {
+		c.t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	}
+}
+
+// Concurrent uploads of one file each write a temporary file of their own,
+// even when partial uploads are kept.
+func TestConcurrentKeptUploadsDoNotShareTemporaryFile(t *testing.T) {
+	root := t.TempDir()
+	c := newTestConn(t, RootDirectory(root), AtomicUploads(KeepPartialUploads))
+
+	first := openUpload(c, 1, "/file")
+	second := openUpload(c, 2, "/file")
+	writeUpload(c, 3, second, "second upload")
+	writeUpload(c, 4, first, "first")
+
+	if code := c.status(&sshFXPClosePacket{ID: 5, Handle: second}); code != SSH_FX_OK {
+		t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	} else if code := c.status(&sshFXPClosePacket{ID: 6, Handle: first}); code != SSH_FX_OK {
+		t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	}
+
+	if b, err := os.ReadFile(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "first" {
+		t.Fatalf("Expected the last upload closed to win, found %q", b)
+	}
+
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Fatalf("Expected only the uploaded file left, found %d files", len(entries))
+	}
+}
+
+func TestVetoedAtomicUploadIsNeverVisible(t *testing.T) {
+	root := t.TempDir()
+	vetoed := errors.New("Rejected")
+	hooks := FileHooks{OnUpload: func(event FileEvent) error {
+		if _, err := os.Stat(filepath.Join(root, "file")); !os.IsNotExist(err) {
+			t.Error("Upload visible before OnUpload was called")
+		}
+
+		return vetoed
+	}}
+
+	c := newTestConn(t, RootDirectory(root), AtomicUploads(KeepPartialUploads), Hooks(hooks))
+	handle := openUpload(c, 1, "/file")
+	writeUpload(c, 2, handle, "rejected")
+	expectStatus(t, SSH_FX_FAILURE, c.status(&sshFXPClosePacket{ID: 3, Handle: handle}))
+
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Fatalf("Expected the vetoed upload discarded, found %d files", len(entries))
+	}
+}
+
+// A kept upload is released when its session drops, for the next upload to
+// pick up.
+func TestKeptUploadIsResumedAfterDrop(t *testing.T) {
+	root := t.TempDir()
+	server, rwc := startServer(t, RootDirectory(root), AtomicUploads(KeepPartialUploads))
+	c := handshake(t, rwc)
+	writeUpload(c, 2, openUpload(c, 1, "/file"), "partial")
+	rwc.Close()
+	<-server.done
+
+	c = newTestConn(t, RootDirectory(root), AtomicUploads(KeepPartialUploads))
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+	if code := c.status(&sshFXPWritePacket{ID: 2, Handle: handle, Offset: 7, Data: []byte(" upload")}); code != SSH_FX_OK {
+		t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	} else if code := c.status(&sshFXPClosePacket{ID: 3, Handle: handle}); code != SSH_FX_OK {
+		t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	}
+
+	if b, err := os.ReadFile(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "partial upload" {
+		t.Fatalf("Expected the partial upload resumed, found %q", b)
+	}
+}
+
+// Writing over an existing file without asking for it to be created still
+// needs a temporary file to be.
+func TestAtomicUploadTruncatesWithoutCreating(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "old contents"})
+	c := newTestConn(t, RootDirectory(root), AtomicUploads(DiscardPartialUploads))
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_TRUNC})
+	writeUpload(c, 2, handle, "new")
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPClosePacket{ID: 3, Handle: handle}))
+
+	if b, err := os.ReadFile(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "new" {
+		t.Fatalf("Expected the file replaced, found %q", b)
+	}
+
+	expectStatus(t, SSH_FX_NO_SUCH_FILE, c.status(&sshFXPOpenPacket{ID: 4, Filename: "/missing", PFlags: SSH_FXF_WRITE | SSH_FXF_TRUNC}))
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Fatalf("Expected no temporary file left, found %d files", len(entries))
+	}
+}
+
+// A claim left behind by a process that died is broken by the next upload,
+// which resumes the partial upload.
+func TestStaleClaimIsBroken(t *testing.T) {
+	dead := exec.Command(os.Args[0], "-test.run=^$")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := newTestRoot(t, map[string]string{
+		".file.partial":      "partial",
+		".file.partial.lock": fmt.Sprintf("%s %d\n", host, dead.Process.Pid),
+	})
+
+	c := newTestConn(t, RootDirectory(root), AtomicUploads(KeepPartialUploads))
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPWritePacket{ID: 2, Handle: handle, Offset: 7, Data: []byte(" upload")}))
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPClosePacket{ID: 3, Handle: handle}))
+
+	if b, err := os.ReadFile(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "partial upload" {
+		t.Fatalf("Expected the partial upload resumed, found %q", b)
+	}
+
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Fatalf("Expected the claim released, found %d files", len(entries))
+	}
+}
+
+func TestLiveClaimIsKept(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := fmt.Sprintf("%s %d\n", host, os.Getpid())
+	root := newTestRoot(t, map[string]string{
+		".file.partial":      "partial",
+		".file.partial.lock": owner,
+	})
+
+	c := newTestConn(t, RootDirectory(root), AtomicUploads(KeepPartialUploads))
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+	writeUpload(c, 2, handle, "upload")
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPClosePacket{ID: 3, Handle: handle}))
+
+	if b, err := os.ReadFile(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "upload" {
+		t.Fatalf("Expected an upload of its own, found %q", b)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(root, ".file.partial.lock")); err != nil || string(b) != owner {
+		t.Fatalf("Expected the live claim kept, found %q (%v)", b, err)
+	}
+}
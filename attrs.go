@@ -1,16 +1,110 @@
 package bsftp
 
+import "os"
+
 const (
 	SSH_FILEXFER_ATTR_SIZE        = 0x00000001
     SSH_FILEXFER_ATTR_UIDGID      = 0x00000002
     SSH_FILEXFER_ATTR_PERMISSIONS = 0x00000004
     SSH_FILEXFER_ATTR_ACMODTIME   = 0x00000008
-    // SSH_FILEXFER_ATTR_EXTENDED    = 0x80000000
+    SSH_FILEXFER_ATTR_EXTENDED    = 0x80000000
 )
 
+// File type and mode bits of the `permissions' field, as defined by posix.
+const (
+	S_IFMT   = 0170000
+	S_IFSOCK = 0140000
+	S_IFLNK  = 0120000
+	S_IFREG  = 0100000
+	S_IFBLK  = 0060000
+	S_IFDIR  = 0040000
+	S_IFCHR  = 0020000
+	S_IFIFO  = 0010000
+	S_ISUID  = 0004000
+	S_ISGID  = 0002000
+	S_ISVTX  = 0001000
+)
+
+// toFileMode converts a posix `permissions' field into an os.FileMode.
+func toFileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	switch perm & S_IFMT {
+		case S_IFDIR: mode |= os.ModeDir
+		case S_IFLNK: mode |= os.ModeSymlink
+		case S_IFIFO: mode |= os.ModeNamedPipe
+		case S_IFSOCK: mode |= os.ModeSocket
+		case S_IFBLK: mode |= os.ModeDevice
+		case S_IFCHR: mode |= os.ModeDevice | os.ModeCharDevice
+	}
+
+	if perm&S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if perm&S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if perm&S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+// fromFileMode converts an os.FileMode into a posix `permissions' field.
+func fromFileMode(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	switch {
+		case mode&os.ModeDir != 0: perm |= S_IFDIR
+		case mode&os.ModeSymlink != 0: perm |= S_IFLNK
+		case mode&os.ModeNamedPipe != 0: perm |= S_IFIFO
+		case mode&os.ModeSocket != 0: perm |= S_IFSOCK
+		case mode&os.ModeCharDevice != 0: perm |= S_IFCHR
+		case mode&os.ModeDevice != 0: perm |= S_IFBLK
+		case mode&os.ModeType == 0: perm |= S_IFREG
+	}
+
+	if mode&os.ModeSetuid != 0 {
+		perm |= S_ISUID
+	}
+
+	if mode&os.ModeSetgid != 0 {
+		perm |= S_ISGID
+	}
+
+	if mode&os.ModeSticky != 0 {
+		perm |= S_ISVTX
+	}
+
+	return perm
+}
+
+// fileAttributesFromInfo describes a local file to the client.
+func fileAttributesFromInfo(fi os.FileInfo) fileAttributes {
+	mtime := uint32(fi.ModTime().Unix())
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_SIZE | SSH_FILEXFER_ATTR_PERMISSIONS | SSH_FILEXFER_ATTR_ACMODTIME,
+		Stat: attrs{
+			Size:        uint64(fi.Size()),
+			Permissions: fromFileMode(fi.Mode()),
+			ATime:       mtime,
+			MTime:       mtime,
+		},
+	}
+
+	if uid, gid, ok := fileOwner(fi); ok {
+		fAttrs.Flags |= SSH_FILEXFER_ATTR_UIDGID
+		fAttrs.Stat.UID, fAttrs.Stat.GID = uid, gid
+	}
+
+	return fAttrs
+}
+
 type fileAttributes struct {
-	Flags uint32
-	Stat  attrs
+	Flags    uint32
+	Stat     attrs
+	Extended []extensionPair
 }
 
 /*
@@ -25,6 +119,11 @@ type fileAttributes struct {
 	The `atime' and `mtime' contain the access and modification times of
 	the files, respectively.  They are represented as seconds from Jan 1,
 	1970 in UTC.
+
+	The SSH_FILEXFER_ATTR_EXTENDED flag provides a general extension
+	mechanism for vendor-specific extensions.  If the flag is specified,
+	then the `extended_count' field is present.  It specifies the number
+	of extended_type-extended_data pairs that follow.
 */
 
 type attrs struct {
@@ -57,6 +156,11 @@ func marshalFileAttributes(b []byte, v fileAttributes) []byte {
 		b = marshalUint32(b, v.Stat.MTime)
 	}
 
+	if v.Flags&SSH_FILEXFER_ATTR_EXTENDED == SSH_FILEXFER_ATTR_EXTENDED {
+		b = marshalUint32(b, uint32(len(v.Extended)))
+		b = marshalExtensions(b, v.Extended)
+	}
+
 	return b
 }
 
@@ -84,5 +188,21 @@ func unmarshalFileAttributesSafe(b []byte) (fileAttributes, []byte, error) {
 		if fAttrs.Stat.MTime, b, err = unmarshalUint32Safe(b); err != nil { return fAttrs, nil, err }
 	}
 
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_EXTENDED == SSH_FILEXFER_ATTR_EXTENDED {
+		var count uint32
+		if count, b, err = unmarshalUint32Safe(b); err != nil { return fAttrs, nil, err }
+
+		// each pair takes at least two empty strings, so a count the packet cannot hold is refused before allocating
+		if uint64(count) > uint64(len(b)/(UINT32_COST*2)) {
+			return fAttrs, nil, shortPacketError
+		}
+
+		fAttrs.Extended = make([]extensionPair, count)
+		for i := range fAttrs.Extended {
+			if fAttrs.Extended[i].ExtensionName, b, err = unmarshalStringSafe(b); err != nil { return fAttrs, nil, err }
+			if fAttrs.Extended[i].ExtensionData, b, err = unmarshalStringSafe(b); err != nil { return fAttrs, nil, err }
+		}
+	}
+
 	return fAttrs, b, err
 }
\ No newline at end of file
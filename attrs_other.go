@@ -0,0 +1,9 @@
+//go:build !unix
+
+package bsftp
+
+import "os"
+
+func fileOwner(fi os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
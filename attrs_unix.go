@@ -0,0 +1,16 @@
+//go:build unix
+
+package bsftp
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileOwner(fi os.FileInfo) (uint32, uint32, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid, true
+	}
+
+	return 0, 0, false
+}
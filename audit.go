@@ -0,0 +1,214 @@
+package bsftp
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditEvent records a single file operation. Reads and writes are not
+// recorded one by one, but summarized when their handle is closed, in which
+// case Time and Duration span the whole time the file was open.
+type AuditEvent struct {
+	Time         time.Time     `json:"time"`
+	User         string        `json:"user"`
+	RemoteAddr   string        `json:"remote_addr,omitempty"`
+	Operation    string        `json:"operation"`
+	Path         string        `json:"path"`
+	TargetPath   string        `json:"target_path,omitempty"`
+	BytesRead    uint64        `json:"bytes_read,omitempty"`
+	BytesWritten uint64        `json:"bytes_written,omitempty"`
+	StatusCode   uint32        `json:"status"`
+	Duration     time.Duration `json:"duration_ns"`
+	// why a handle was closed other than at the client's request
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink receives an event for every file operation once it has been
+// answered. It is called concurrently by the server's workers.
+type AuditSink interface {
+	Audit(event AuditEvent)
+}
+
+// auditEvent begins the record of a request, or returns nil for requests
+// that are not audited.
+func (s *Server) auditEvent(p encoding.BinaryUnmarshaler) *AuditEvent {
+	if s.auditSink == nil {
+		return nil
+	}
+
+	event := &AuditEvent{}
+
+	switch p := p.(type) {
+		case *sshFXPOpenPacket: event.Operation, event.Path = "open", p.Filename
+		case *sshFXPSetStatPacket: event.Operation, event.Path = "setstat", p.Path
+		case *sshFXPRemovePacket: event.Operation, event.Path = "remove", p.Filename
+		case *sshFXPMkDirPacket: event.Operation, event.Path = "mkdir", p.Path
+		case *sshFXPRmDirPacket: event.Operation, event.Path = "rmdir", p.Path
+		case *sshFXPRenamePacket: event.Operation, event.Path, event.TargetPath = "rename", p.OldPath, p.NewPath
+		case *sshFXPSymlinkPacket:
+			link, target := p.linkAndTarget()
+			event.Operation, event.Path, event.TargetPath = "symlink", link, target
+		case *sshFXPFSetStatPacket:
+			f, err := s.getHandle(p.Handle)
+			if err != nil {
+				return nil
+			}
+
+			event.Operation, event.Path = "fsetstat", f.Path
+		case *sshFXPClosePacket:
+			f, err := s.getHandle(p.Handle)
+			if err != nil {
+				return nil
+			}
+
+			*event = closeEvent(f)
+		case *sshFXPExtendedPacket:
+			if p.ExtendedRequest != LSETSTAT_EXTENSION {
+				return nil
+			}
+
+			name, _, err := unmarshalStringSafe(p.RequestData)
+			if err != nil {
+				return nil
+			}
+
+			event.Operation, event.Path = "lsetstat", name
+		default:
+			return nil
+	}
+
+	s.attributeEvent(event)
+	if event.Operation != "close" {
+		event.Path = s.realPath(event.Path)
+		if event.TargetPath != "" {
+			event.TargetPath = s.realPath(event.TargetPath)
+		}
+	}
+
+	return event
+}
+
+// closeEvent begins the record of a handle being closed, which spans the
+// time it was open.
+func closeEvent(f *openFile) AuditEvent {
+	return AuditEvent{
+		Time:         f.Opened,
+		Operation:    "close",
+		Path:         f.Path,
+		BytesRead:    atomic.LoadUint64(&f.BytesRead),
+		BytesWritten: atomic.LoadUint64(&f.BytesWritten),
+	}
+}
+
+// attributeEvent records who the event happened to.
+func (s *Server) attributeEvent(event *AuditEvent) {
+	event.User = s.username
+	if s.remoteAddr != nil {
+		event.RemoteAddr = s.remoteAddr.String()
+	}
+}
+
+// audit completes the record of a request with its outcome and hands it to
+// the sink.
+func (s *Server) audit(event *AuditEvent, reply packetEncoder, start time.Time) {
+	if event.Time.IsZero() {
+		event.Time = start
+	}
+
+	event.Duration = time.Since(event.Time)
+	event.StatusCode = replyStatus(reply)
+
+	s.auditSink.Audit(*event)
+}
+
+// JSONAuditSink writes each event as a line of JSON.
+type JSONAuditSink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+	err     error
+}
+
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	sink := &JSONAuditSink{encoder: json.NewEncoder(w)}
+	if closer, ok := w.(io.Closer); ok {
+		sink.closer = closer
+	}
+
+	return sink
+}
+
+// OpenJSONAuditLog appends events to a file, creating it if need be.
+func OpenJSONAuditLog(name string) (*JSONAuditSink, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJSONAuditSink(f), nil
+}
+
+func (j *JSONAuditSink) Audit(event AuditEvent) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.encoder.Encode(event); err != nil && j.err == nil {
+		j.err = err
+	}
+}
+
+// Close closes the underlying writer, reporting the first event that could
+// not be written, if any.
+func (j *JSONAuditSink) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.closer != nil {
+		if err := j.closer.Close(); err != nil && j.err == nil {
+			j.err = err
+		}
+	}
+
+	return j.err
+}
+
+// SlogAuditSink logs each event through a structured logger.
+type SlogAuditSink struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func (l SlogAuditSink) Audit(event AuditEvent) {
+	attrs := []slog.Attr{
+		slog.String("user", event.User),
+		slog.String("operation", event.Operation),
+		slog.String("path", event.Path),
+		slog.Uint64("status", uint64(event.StatusCode)),
+		slog.Duration("duration", event.Duration),
+	}
+
+	if event.RemoteAddr != "" {
+		attrs = append(attrs, slog.String("remote_addr", event.RemoteAddr))
+	}
+
+	if event.TargetPath != "" {
+		attrs = append(attrs, slog.String("target_path", event.TargetPath))
+	}
+
+	if event.BytesRead > 0 || event.BytesWritten > 0 {
+		attrs = append(attrs, slog.Uint64("bytes_read", event.BytesRead), slog.Uint64("bytes_written", event.BytesWritten))
+	}
+
+	if event.Error != "" {
+		attrs = append(attrs, slog.String("error", event.Error))
+	}
+
+	l.Logger.LogAttrs(context.Background(), l.Level, "sftp "+event.Operation, attrs...)
+}
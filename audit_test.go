@@ -0,0 +1,90 @@
+package bsftp
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingAuditSink struct {
+	lock   sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recordingAuditSink) Audit(event AuditEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *recordingAuditSink) closes() []AuditEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var closes []AuditEvent
+	for _, event := range r.events {
+		if event.Operation == "close" {
+			closes = append(closes, event)
+		}
+	}
+
+	return closes
+}
+
+// Uploads abandoned when the client hangs up are audited as closed, and
+// refunded.
+func TestDroppedSessionAuditsOpenHandles(t *testing.T) {
+	root := t.TempDir()
+	sink := &recordingAuditSink{}
+	quotas := NewQuotas()
+	quotas.SetLimit("bob", Quota{Bytes: 1 << 20, Files: 10})
+
+	server, rwc := startServer(t, RootDirectory(root), User("bob"), Audit(sink), DiskQuotas(quotas), AtomicUploads(DiscardPartialUploads))
+	c := handshake(t, rwc)
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/upload", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT | SSH_FXF_TRUNC})
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPWritePacket{ID: 2, Handle: handle, Data: make([]byte, 1000)}))
+
+	rwc.Close()
+	select {
+		case <-server.done:
+		case <-time.After(5 * time.Second): t.Fatal("Server did not stop serving")
+	}
+
+	closes := sink.closes()
+	if len(closes) != 1 {
+		t.Fatalf("Expected 1 close event, found %d", len(closes))
+	}
+
+	event := closes[0]
+	if event.Path != "/upload" || event.User != "bob" || event.BytesWritten != 1000 {
+		t.Fatalf("Unexpected close event %+v", event)
+	} else if event.StatusCode != SSH_FX_CONNECTION_LOST || event.Error != disconnectedError.Error() {
+		t.Fatalf("Expected the close to be audited as a lost connection, found %+v", event)
+	}
+
+	if usage, _ := quotas.Usage("bob"); usage.Bytes != 0 || usage.Files != 0 {
+		t.Fatalf("Expected the abandoned upload refunded, found %+v charged", usage)
+	}
+
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Fatalf("Expected the partial upload discarded, found %d files", len(entries))
+	}
+}
+
+func TestShutdownAuditsOpenHandles(t *testing.T) {
+	sink := &recordingAuditSink{}
+	server, rwc := startServer(t, RootDirectory(t.TempDir()), Audit(sink))
+	c := handshake(t, rwc)
+	c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+
+	if err := server.Shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if closes := sink.closes(); len(closes) != 1 || closes[0].Error != shutdownError.Error() {
+		t.Fatalf("Expected 1 close audited as shut down, found %+v", closes)
+	}
+}
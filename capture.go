@@ -0,0 +1,170 @@
+package bsftp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A capture starts with its magic and version, followed by a record for each
+// packet: its direction, when it crossed the connection in nanoseconds since
+// the epoch, and the packet itself, type and body, prefixed by its length.
+const (
+	CAPTURE_MAGIC   = "BSFTPCAP"
+	CAPTURE_VERSION = 1
+)
+
+const captureRecordHeaderSize = UINT8_COST + UINT64_COST + UINT32_COST
+
+// CaptureTracer writes every packet it is handed to a binary capture. The
+// contents of reads and writes are zeroed before they are written, so a
+// capture records what was done to which files but never what they hold.
+type CaptureTracer struct {
+	lock   sync.Mutex
+	writer *bufio.Writer
+	closer io.Closer
+	buf    []byte
+	err    error
+}
+
+func NewCaptureTracer(w io.Writer) *CaptureTracer {
+	capture := &CaptureTracer{writer: bufio.NewWriter(w)}
+	if closer, ok := w.(io.Closer); ok {
+		capture.closer = closer
+	}
+
+	capture.buf = append(capture.buf, CAPTURE_MAGIC...)
+	capture.buf = binary.BigEndian.AppendUint32(capture.buf, CAPTURE_VERSION)
+	_, capture.err = capture.writer.Write(capture.buf)
+	return capture
+}
+
+// CreateCapture writes a capture to a new file, truncating any already there.
+func CreateCapture(name string) (*CaptureTracer, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCaptureTracer(f), nil
+}
+
+func (c *CaptureTracer) Trace(p TracedPacket) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.buf = append(c.buf[:0], byte(p.Direction))
+	c.buf = binary.BigEndian.AppendUint64(c.buf, uint64(p.Time.UnixNano()))
+	c.buf = binary.BigEndian.AppendUint32(c.buf, uint32(len(p.Packet)))
+	c.buf = append(c.buf, p.Packet...)
+	redactPacket(p, c.buf[captureRecordHeaderSize:])
+
+	_, c.err = c.writer.Write(c.buf)
+}
+
+// redactPacket zeroes the file contents a packet carries. Both DATA and WRITE
+// end with their contents, whose length the packet was described with.
+func redactPacket(p TracedPacket, b []byte) {
+	if p.Malformed || (p.Type != SSH_FXP_DATA && p.Type != SSH_FXP_WRITE) || p.Length > uint64(len(b)) {
+		return
+	}
+
+	clear(b[uint64(len(b))-p.Length:])
+}
+
+// Flush writes out any records still buffered.
+func (c *CaptureTracer) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err == nil {
+		c.err = c.writer.Flush()
+	}
+
+	return c.err
+}
+
+// Close flushes the capture and closes the underlying writer, reporting the
+// first record that could not be written, if any.
+func (c *CaptureTracer) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err == nil {
+		c.err = c.writer.Flush()
+	}
+
+	if c.closer != nil {
+		if err := c.closer.Close(); err != nil && c.err == nil {
+			c.err = err
+		}
+	}
+
+	return c.err
+}
+
+// CaptureReader reads back the packets of a capture.
+type CaptureReader struct {
+	reader *bufio.Reader
+	buf    []byte
+}
+
+// NewCaptureReader checks the capture's magic and version before reading any
+// packets.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	capture := &CaptureReader{reader: bufio.NewReader(r)}
+
+	var header [len(CAPTURE_MAGIC) + UINT32_COST]byte
+	if _, err := io.ReadFull(capture.reader, header[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, notCaptureError
+	} else if err != nil {
+		return nil, err
+	}
+
+	if string(header[:len(CAPTURE_MAGIC)]) != CAPTURE_MAGIC {
+		return nil, notCaptureError
+	} else if binary.BigEndian.Uint32(header[len(CAPTURE_MAGIC):]) != CAPTURE_VERSION {
+		return nil, unsupportedCaptureError
+	}
+
+	return capture, nil
+}
+
+// Next reads the next packet, returning io.EOF once there are none left. The
+// packet's bytes are only valid until the next call.
+func (c *CaptureReader) Next() (TracedPacket, error) {
+	var header [captureRecordHeaderSize]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err == io.ErrUnexpectedEOF {
+		return TracedPacket{}, errors.Wrap(err, "Failed to read capture record")
+	} else if err != nil {
+		return TracedPacket{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[UINT8_COST+UINT64_COST:])
+	if length > MaxRxPacketSize {
+		return TracedPacket{}, longPacketError
+	}
+
+	if cap(c.buf) < int(length) {
+		c.buf = make([]byte, length)
+	}
+
+	c.buf = c.buf[:length]
+	if _, err := io.ReadFull(c.reader, c.buf); err != nil {
+		return TracedPacket{}, errors.Wrap(err, "Failed to read captured packet")
+	}
+
+	p := DescribePacket(c.buf)
+	p.Direction = TraceDirection(header[0])
+	p.Time = time.Unix(0, int64(binary.BigEndian.Uint64(header[UINT8_COST:])))
+	return p, nil
+}
@@ -0,0 +1,173 @@
+package bsftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"strings"
+)
+
+const (
+	CHECK_FILE_EXTENSION        = "check-file"
+	CHECK_FILE_HANDLE_EXTENSION = "check-file-handle"
+)
+
+// minCheckFileBlockSize is the smallest non-zero block size the draft allows,
+// keeping a client from having every few bytes hashed on their own.
+const minCheckFileBlockSize = 256
+
+// checkFileHashes are the algorithms check-file requests may ask for, in
+// the server's order of preference.
+var checkFileHashes = []struct {
+	Name string
+	New  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha512", sha512.New},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+}
+
+// checkFileHash picks the first algorithm of a comma-separated list that the
+// server supports.
+func checkFileHash(names string) (string, func() hash.Hash, error) {
+	for _, name := range strings.Split(names, ",") {
+		for _, h := range checkFileHashes {
+			if h.Name == name {
+				return h.Name, h.New, nil
+			}
+		}
+	}
+
+	return "", nil, unsupportedHashError
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "check-file-handle"
+	string handle
+	string hash-algorithm-list
+	uint64 start-offset
+	uint64 length
+	uint32 block-size
+
+	Hashes `length' bytes of an open file starting at `start-offset', or up to
+	the end of the file when `length' is zero. A non-zero `block-size', of at
+	least 256 bytes, hashes each block of that many bytes separately; the
+	request fails if the hashes would not fit in a single reply. The reply is
+	an SSH_FXP_EXTENDED_REPLY:
+
+	string "check-file"
+	string hash-algorithm-used
+	byte[] hashes, one after another
+*/
+func (s *Server) handleCheckFileHandle(p *sshFXPExtendedPacket) packetEncoder {
+	handle, b, err := unmarshalStringSafe(p.RequestData)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	names, b, err := unmarshalStringSafe(b)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	offset, b, err := unmarshalUint64Safe(b)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	length, b, err := unmarshalUint64Safe(b)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	blockSize, _, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	// the hashes give the contents away as surely as reading them would
+	f, err := s.getReadableHandle(handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	name, newHash, err := checkFileHash(names)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	r, err := s.reopen(f)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	defer r.Close()
+
+	data := marshalString(nil, CHECK_FILE_EXTENSION)
+	data = marshalString(data, name)
+
+	// the reply, less its hashes, leaves this much room for them
+	room := MaxTxPacketSize - (UINT32_COST + UINT8_COST + UINT32_COST + len(data))
+	hashes, err := hashFileBlocks(r, newHash, int64(offset), int64(length), int64(blockSize), room)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return sshFXPExtendedReplyPacket{ID: p.ID, Data: append(data, hashes...)}
+}
+
+// hashFileBlocks hashes a range of a file, block by block, refusing to
+// produce more than room bytes of hashes.
+func hashFileBlocks(f handleFile, newHash func() hash.Hash, offset, length, blockSize int64, room int) ([]byte, error) {
+	if blockSize != 0 && blockSize < minCheckFileBlockSize {
+		return nil, smallBlockSizeError
+	}
+
+	if length == 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		length = fi.Size() - offset
+	}
+
+	if length < 0 {
+		length = 0
+	}
+
+	if blockSize <= 0 || blockSize > length {
+		blockSize = length
+	}
+
+	if blockSize == 0 {
+		return newHash().Sum(nil), nil
+	}
+
+	if blocks := (length + blockSize - 1) / blockSize; blocks > int64(room/newHash().Size()) {
+		return nil, tooManyBlocksError
+	}
+
+	var hashes []byte
+	r := io.NewSectionReader(f, offset, length)
+	for {
+		h := newHash()
+		n, err := io.CopyN(h, r, blockSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if n > 0 {
+			hashes = h.Sum(hashes)
+		}
+
+		if n < blockSize || err == io.EOF {
+			return hashes, nil
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package bsftp
+
+import "testing"
+
+func checkFileRequest(id uint32, handle string, length uint64, blockSize uint32) *sshFXPExtendedPacket {
+	data := marshalString(nil, handle)
+	data = marshalString(data, "md5")
+	data = marshalUint64(data, 0)
+	data = marshalUint64(data, length)
+	data = marshalUint32(data, blockSize)
+	return &sshFXPExtendedPacket{ID: id, ExtendedRequest: CHECK_FILE_HANDLE_EXTENSION, RequestData: data}
+}
+
+func TestCheckFileBlockSizes(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": string(make([]byte, 1<<20))})
+
+	c := newTestConn(t, RootDirectory(root))
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_READ})
+
+	// too small a block, or too many blocks for one reply
+	for id, blockSize := range map[uint32]uint32{2: 1, 3: 255, 4: 256} {
+		if code := c.status(checkFileRequest(id, handle, 0, blockSize)); code != SSH_FX_FAILURE {
+			t.Fatalf("Block size %d: expected SSH_FX_FAILURE, received %s", blockSize, statusCodeName(code))
+		}
+	}
+
+	tahyp, data := c.roundTrip(checkFileRequest(5, handle, 0, 1<<16))
+	if tahyp != SSH_FXP_EXTENDED_REPLY {
+		t.Fatalf("Expected SSH_FXP_EXTENDED_REPLY, received %s", packetTypeName(tahyp))
+	}
+
+	reply := &sshFXPExtendedReplyPacket{}
+	if err := reply.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	_, b, _ := unmarshalStringSafe(reply.Data)
+	if _, b, _ = unmarshalStringSafe(b); len(b) != 16*16 {
+		t.Fatalf("Expected 16 md5 hashes, found %d bytes of them", len(b))
+	}
+}
@@ -0,0 +1,388 @@
+package bsftp
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// clientChunkSize is the most data the client reads or writes in one request
+// unless the server advertises its limits, small enough for any server to
+// accept.
+const clientChunkSize = 1 << 15
+
+// File is a remote file opened by a Client. Transfers larger than a single
+// request are split into chunks, several of which are kept outstanding at
+// once so that throughput does not hinge on latency.
+type File struct {
+	client *Client
+	name   string
+	handle string
+	flags  int
+	offset int64
+	lock   sync.Mutex
+}
+
+// pendingChunk is a READ or WRITE awaiting its reply.
+type pendingChunk struct {
+	reply <-chan clientReply
+	buf   []byte
+	off   int64
+}
+
+// Name returns the name the file was opened with.
+func (f *File) Name() string {
+	return f.name
+}
+
+// concurrency is how many requests a transfer keeps outstanding. Appends land
+// wherever the file ends when they arrive, so they are sent one at a time.
+func (f *File) concurrency() int {
+	if f.flags&os.O_APPEND != 0 {
+		return 1
+	}
+
+	return f.client.concurrency
+}
+
+func (f *File) Read(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	n, err := f.ReadAt(b, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// ReadAt fills b from the file starting at off, returning io.EOF if the file
+// ends first.
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	var queue []pendingChunk
+	var next int
+	// where the file ended or a read failed; nothing past it counts
+	limit, limitErr := len(b), error(nil)
+
+	for next < limit || len(queue) > 0 {
+		for next < limit && len(queue) < f.concurrency() {
+			chunk := b[next:]
+			if len(chunk) > f.client.readLength {
+				chunk = chunk[:f.client.readLength]
+			}
+
+			reply, err := f.dispatchRead(len(chunk), off+int64(next))
+			if err != nil {
+				limit, limitErr = next, err
+				break
+			}
+
+			queue = append(queue, pendingChunk{reply: reply, buf: chunk, off: off + int64(next)})
+			next += len(chunk)
+		}
+
+		if len(queue) == 0 {
+			break
+		}
+
+		head := queue[0]
+		queue = queue[1:]
+		start := int(head.off - off)
+		if start >= limit {
+			continue
+		}
+
+		n, err := receiveData(<-head.reply, head.buf)
+		switch {
+			case err != nil, n == 0:
+				if err == nil {
+					err = io.ErrNoProgress
+				}
+
+				if start+n < limit {
+					limit, limitErr = start+n, err
+				}
+			case n < len(head.buf):
+				// a short read; ask again for the rest of the chunk
+				reply, err := f.dispatchRead(len(head.buf)-n, head.off+int64(n))
+				if err != nil {
+					limit, limitErr = start+n, err
+					continue
+				}
+
+				queue = append(queue, pendingChunk{reply: reply, buf: head.buf[n:], off: head.off + int64(n)})
+		}
+	}
+
+	return limit, limitErr
+}
+
+// dispatchRead sends a single SSH_FXP_READ, which the server may answer with
+// less data than asked for.
+func (f *File) dispatchRead(length int, off int64) (<-chan clientReply, error) {
+	id := f.client.newRequestID()
+	return f.client.dispatch(id, sshFXPReadPacket{ID: id, Handle: f.handle, Offset: uint64(off), Len: uint32(length)})
+}
+
+// receiveData copies the data of a reply to SSH_FXP_READ into b.
+func receiveData(reply clientReply, b []byte) (int, error) {
+	defer reply.release()
+
+	data := sshFXPDataPacket{}
+	if err := decodeReply(reply, SSH_FXP_DATA, &data); err != nil {
+		return 0, err
+	} else if len(data.Data) > len(b) {
+		return 0, longPacketError
+	}
+
+	return copy(b, data.Data), nil
+}
+
+// WriteTo copies the file from its offset to w, keeping several reads
+// outstanding and writing their data out in order. It lets io.Copy download
+// without waiting on each read in turn.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var queue []pendingChunk
+	var free [][]byte
+	var written int64
+	next := f.offset
+	defer func() { f.offset += written }()
+
+	for {
+		for len(queue) < f.concurrency() {
+			var buf []byte
+			if len(free) > 0 {
+				buf, free = free[len(free)-1], free[:len(free)-1]
+			} else {
+				buf = make([]byte, f.client.readLength)
+			}
+
+			reply, err := f.dispatchRead(len(buf), next)
+			if err != nil {
+				return written, err
+			}
+
+			queue = append(queue, pendingChunk{reply: reply, buf: buf, off: next})
+			next += int64(len(buf))
+		}
+
+		head := queue[0]
+		queue = queue[1:]
+		n, err := receiveData(<-head.reply, head.buf)
+		if err == nil && n == 0 {
+			err = io.ErrNoProgress
+		} else if err == nil && n < len(head.buf) {
+			// a short read; fill in the rest before moving on
+			var m int
+			m, err = f.ReadAt(head.buf[n:], head.off+int64(n))
+			n += m
+		}
+
+		if n > 0 {
+			m, werr := w.Write(head.buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+
+		if err == io.EOF {
+			return written, nil
+		} else if err != nil {
+			return written, err
+		}
+
+		free = append(free, head.buf)
+	}
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	n, err := f.WriteAt(b, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes b to the file starting at off.
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	var queue []pendingChunk
+	var next, written int
+
+	for next < len(b) || len(queue) > 0 {
+		for next < len(b) && len(queue) < f.concurrency() {
+			chunk := b[next:]
+			if len(chunk) > f.client.writeLength {
+				chunk = chunk[:f.client.writeLength]
+			}
+
+			reply, err := f.dispatchWrite(chunk, off+int64(next))
+			if err != nil {
+				return written, err
+			}
+
+			queue = append(queue, pendingChunk{reply: reply, buf: chunk, off: off + int64(next)})
+			next += len(chunk)
+		}
+
+		head := queue[0]
+		queue = queue[1:]
+		if err := receiveStatus(<-head.reply); err != nil {
+			return written, err
+		}
+
+		written += len(head.buf)
+	}
+
+	return written, nil
+}
+
+// dispatchWrite sends a single SSH_FXP_WRITE. The data is copied as the
+// request is encoded, so b may be reused as soon as it returns.
+func (f *File) dispatchWrite(b []byte, off int64) (<-chan clientReply, error) {
+	id := f.client.newRequestID()
+	return f.client.dispatch(id, sshFXPWritePacket{ID: id, Handle: f.handle, Offset: uint64(off), Data: b})
+}
+
+// ReadFrom copies r to the file from its offset, keeping several writes
+// outstanding. It lets io.Copy upload without waiting on each write in turn.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var queue []pendingChunk
+	var written int64
+	var readErr error
+	buf := make([]byte, f.client.writeLength)
+
+	for readErr == nil || len(queue) > 0 {
+		for readErr == nil && len(queue) < f.concurrency() {
+			var n int
+			n, readErr = io.ReadFull(r, buf)
+			if n == 0 {
+				break
+			}
+
+			reply, err := f.dispatchWrite(buf[:n], f.offset)
+			if err != nil {
+				return written, err
+			}
+
+			queue = append(queue, pendingChunk{reply: reply, buf: buf[:n], off: f.offset})
+			f.offset += int64(n)
+		}
+
+		if len(queue) == 0 {
+			break
+		}
+
+		head := queue[0]
+		queue = queue[1:]
+		if err := receiveStatus(<-head.reply); err != nil {
+			f.offset = head.off
+			return written, err
+		}
+
+		written += int64(len(head.buf))
+	}
+
+	if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+		readErr = nil
+	}
+
+	return written, readErr
+}
+
+// receiveStatus is the outcome of a request answered by SSH_FXP_STATUS.
+func receiveStatus(reply clientReply) error {
+	defer reply.release()
+
+	if reply.Err != nil {
+		return reply.Err
+	} else if reply.Type != SSH_FXP_STATUS {
+		return unexpectedPacketError
+	}
+
+	status := sshFXPStatusPacket{}
+	if err := status.UnmarshalBinary(reply.Data); err != nil {
+		return err
+	}
+
+	return errorFromStatus(status)
+}
+
+// Seek sets the offset of the next Read or Write.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch whence {
+		case io.SeekCurrent: offset += f.offset
+		case io.SeekEnd:
+			fi, err := f.Stat()
+			if err != nil {
+				return f.offset, err
+			}
+
+			offset += fi.Size()
+	}
+
+	if offset < 0 {
+		return f.offset, os.ErrInvalid
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Stat describes the open file.
+func (f *File) Stat() (os.FileInfo, error) {
+	id := f.client.newRequestID()
+	reply := sshFXPAttrsPacket{}
+	if err := f.client.request(id, sshFXPFStatPacket{ID: id, Handle: f.handle}, SSH_FXP_ATTRS, &reply); err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(f.name, reply.Attrs), nil
+}
+
+// Chmod changes the permissions of the open file.
+func (f *File) Chmod(mode os.FileMode) error {
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_PERMISSIONS,
+		Stat:  attrs{Permissions: fromFileMode(mode) &^ S_IFMT},
+	}
+
+	return f.setStat("chmod", fAttrs)
+}
+
+// Truncate changes the size of the open file.
+func (f *File) Truncate(size int64) error {
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_SIZE,
+		Stat:  attrs{Size: uint64(size)},
+	}
+
+	return f.setStat("truncate", fAttrs)
+}
+
+func (f *File) setStat(op string, fAttrs fileAttributes) error {
+	id := f.client.newRequestID()
+	if err := f.client.requestStatus(id, sshFXPFSetStatPacket{ID: id, Handle: f.handle, Attrs: fAttrs}); err != nil {
+		return &os.PathError{Op: op, Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// Close closes the remote handle.
+func (f *File) Close() error {
+	id := f.client.newRequestID()
+	return f.client.requestStatus(id, sshFXPClosePacket{ID: id, Handle: f.handle})
+}
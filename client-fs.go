@@ -0,0 +1,173 @@
+package bsftp
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// FS presents a remote directory tree as an fs.FS, so that it can be handed
+// to fs.WalkDir, template.ParseFS, http.FS and the like.
+type FS struct {
+	client *Client
+	root   string
+}
+
+// FS returns an fs.FS of the remote tree rooted at a directory.
+func (c *Client) FS(root string) *FS {
+	return &FS{client: c, root: root}
+}
+
+// remotePath turns a name valid in the fs.FS into the path of the remote
+// file it names.
+func (fsys *FS) remotePath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return path.Join(fsys.root, name), nil
+}
+
+// fsError reports a client error in terms of the fs.FS, hiding where its
+// root lies on the server.
+func fsError(op, name string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	remote, err := fsys.remotePath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fsys.client.Stat(remote)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	} else if fi.IsDir() {
+		return &fsDir{fsys: fsys, name: name, info: fi}, nil
+	}
+
+	f, err := fsys.client.Open(remote)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+
+	return f, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	remote, err := fsys.remotePath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fsys.client.Stat(remote)
+	if err != nil {
+		return nil, fsError("stat", name, err)
+	}
+
+	return fi, nil
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	remote, err := fsys.remotePath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := fsys.client.ReadDir(remote)
+	if err != nil {
+		return nil, fsError("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, fi := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(fi))
+	}
+
+	return entries, nil
+}
+
+// ReadFile reads a whole remote file, keeping several reads outstanding.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	remote, err := fsys.remotePath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := fsys.client.Open(remote)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if fi, err := f.Stat(); err == nil {
+		buf.Grow(int(fi.Size()))
+	}
+
+	if _, err := f.WriteTo(&buf); err != nil {
+		return nil, fsError("read", name, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fsDir is a remote directory opened through an FS, listed when first read.
+type fsDir struct {
+	fsys    *FS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: isDirectoryError}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir lists the next n entries of the directory, or all that remain when
+// n is not positive.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	} else if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
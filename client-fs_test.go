@@ -0,0 +1,33 @@
+package bsftp
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+)
+
+func TestClientFS(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"a": "a", "dir/b": "bb", "dir/sub/c": "ccc"})
+	client := newTestClient(t, RootDirectory(root))
+
+	if err := fstest.TestFS(client.FS("/dir"), "b", "sub", "sub/c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientFSErrors(t *testing.T) {
+	client := newTestClient(t, RootDirectory(newTestRoot(t, map[string]string{"file": "file"})))
+	fsys := client.FS("/")
+
+	if _, err := fsys.Open("../file"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected an invalid path refused, found %v", err)
+	}
+
+	_, err := fs.ReadFile(fsys, "missing")
+	var pathErr *fs.PathError
+	if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) || pathErr.Path != "missing" {
+		t.Fatalf("Expected the missing file reported by its name in the fs.FS, found %v", err)
+	}
+}
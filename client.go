@@ -0,0 +1,614 @@
+package bsftp
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Client speaks SFTP to a server over an established channel, typically the
+// "sftp" subsystem of an SSH session. Requests may be made concurrently; each
+// waits for its own reply.
+type Client struct {
+	*connection
+	extensions   map[string]string
+	concurrency  int
+	readLength   int
+	writeLength  int
+	nextID       uint32
+	inflight     map[uint32]chan clientReply
+	inflightLock sync.Mutex
+	err          error
+}
+
+// clientReply is a reply to a request, or the reason none will arrive.
+type clientReply struct {
+	Type byte
+	Data []byte
+	Err  error
+	buf  *[]byte
+}
+
+// release gives the reply's buffer back to the pool. Only a reply whose data
+// has been copied out may be released; others are left to the collector.
+func (r clientReply) release() {
+	if r.buf != nil {
+		putPacketBuffer(r.buf)
+	}
+}
+
+// StatusError is a request's failure as reported by the server.
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", statusCodeName(e.Code), e.Message)
+}
+
+// Is lets errors.Is match a StatusError against fs.ErrNotExist and
+// fs.ErrPermission.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+		case fs.ErrNotExist: return e.Code == SSH_FX_NO_SUCH_FILE
+		case fs.ErrPermission: return e.Code == SSH_FX_PERMISSION_DENIED
+	}
+
+	return false
+}
+
+type ClientOption func(*Client) error
+
+// ConcurrentRequests sets how many reads or writes a single file transfer
+// keeps outstanding at once.
+func ConcurrentRequests(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return invalidConcurrencyError
+		}
+
+		c.concurrency = n
+		return nil
+	}
+}
+
+func NewClient(rwc io.ReadWriteCloser, options ...ClientOption) (*Client, error) {
+	conn := &connection{
+		Reader:      rwc,
+		WriteCloser: rwc,
+	}
+	client := &Client{
+		connection:  conn,
+		extensions:  make(map[string]string),
+		concurrency: 64,
+		readLength:  clientChunkSize,
+		writeLength: clientChunkSize,
+		inflight:    make(map[uint32]chan clientReply),
+	}
+
+	for _, option := range options {
+		if err := option(client); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := client.handshake(); err != nil {
+		rwc.Close()
+		return nil, err
+	}
+
+	go client.recvReplies()
+
+	if _, ok := client.HasExtension(LIMITS_EXTENSION); ok {
+		if err := client.applyLimits(); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// handshake sends SSH_FXP_INIT and records the extensions the server
+// advertises in its SSH_FXP_VERSION.
+func (c *Client) handshake() error {
+	if err := c.sendPacket(sshFXPInitPacket{Version: SFTPProtocolVersionNumber}); err != nil {
+		return err
+	}
+
+	tahyp, data, err := c.recvPacket()
+	if err != nil {
+		return errors.Wrap(err, "Failed to read version packet")
+	} else if tahyp != SSH_FXP_VERSION {
+		return unexpectedPacketError
+	}
+
+	version := sshFXPVersionPacket{}
+	if err := version.UnmarshalBinary(data); err != nil {
+		return err
+	} else if version.Version != SFTPProtocolVersionNumber {
+		return unsupportedVersionError
+	}
+
+	for _, ext := range version.Extensions {
+		c.extensions[ext.ExtensionName] = ext.ExtensionData
+	}
+
+	return nil
+}
+
+// HasExtension reports whether the server advertised an extension, along
+// with the data it advertised it with.
+func (c *Client) HasExtension(name string) (string, bool) {
+	data, ok := c.extensions[name]
+	return data, ok
+}
+
+// applyLimits sizes reads and writes as large as the server allows, which
+// it advertises through the limits extension.
+func (c *Client) applyLimits() error {
+	id := c.newRequestID()
+	reply := sshFXPExtendedReplyPacket{}
+	if err := c.request(id, sshFXPExtendedPacket{ID: id, ExtendedRequest: LIMITS_EXTENSION}, SSH_FXP_EXTENDED_REPLY, &reply); err != nil {
+		return err
+	}
+
+	var limits [4]uint64
+	b := reply.Data
+	for i := range limits {
+		var err error
+		if limits[i], b, err = unmarshalUint64Safe(b); err != nil {
+			return err
+		}
+	}
+
+	// the client accepts packets no larger than the server does, so a read
+	// is also bounded by the largest reply it can receive
+	if read := limits[1]; read > 0 {
+		c.readLength = int(minUint64(read, MaxRxPacketSize-dataPacketHeaderSize))
+	}
+
+	if write := limits[2]; write > 0 {
+		c.writeLength = int(minUint64(write, MaxRxPacketSize))
+	}
+
+	return nil
+}
+
+// Close closes the connection to the server, failing any outstanding
+// requests.
+func (c *Client) Close() error {
+	return c.WriteCloser.Close()
+}
+
+// recvReplies hands every reply to the request awaiting it until the
+// connection fails.
+func (c *Client) recvReplies() {
+	for {
+		reply := clientReply{buf: getPacketBuffer()}
+		b, err := c.readPacket(*reply.buf)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		*reply.buf = b
+		reply.Type, reply.Data = b[0], b[1:]
+		id, _, err := unmarshalUint32Safe(reply.Data)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.inflightLock.Lock()
+		ch, ok := c.inflight[id]
+		delete(c.inflight, id)
+		c.inflightLock.Unlock()
+
+		if ok {
+			ch <- reply
+		} else {
+			reply.release()
+		}
+	}
+}
+
+// fail records why the connection is unusable and fails every outstanding
+// request with it.
+func (c *Client) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	c.inflightLock.Lock()
+	defer c.inflightLock.Unlock()
+
+	c.err = err
+	for id, ch := range c.inflight {
+		ch <- clientReply{Err: err}
+		delete(c.inflight, id)
+	}
+}
+
+func (c *Client) newRequestID() uint32 {
+	return atomic.AddUint32(&c.nextID, 1)
+}
+
+// dispatch sends a request without waiting for its reply, which arrives on
+// the returned channel.
+func (c *Client) dispatch(id uint32, m packetEncoder) (<-chan clientReply, error) {
+	ch := make(chan clientReply, 1)
+
+	c.inflightLock.Lock()
+	if c.err != nil {
+		c.inflightLock.Unlock()
+		return nil, c.err
+	}
+	c.inflight[id] = ch
+	c.inflightLock.Unlock()
+
+	if err := c.sendPacket(m); err != nil {
+		c.inflightLock.Lock()
+		delete(c.inflight, id)
+		c.inflightLock.Unlock()
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// request sends a request and decodes its reply into v, which must be of the
+// expected type. A failure reported in SSH_FXP_STATUS is returned as an error.
+func (c *Client) request(id uint32, m packetEncoder, tahyp byte, v encoding.BinaryUnmarshaler) error {
+	ch, err := c.dispatch(id, m)
+	if err != nil {
+		return err
+	}
+
+	reply := <-ch
+	// bar raw bytes, replies are decoded into copies
+	if tahyp != SSH_FXP_DATA && tahyp != SSH_FXP_EXTENDED_REPLY {
+		defer reply.release()
+	}
+
+	return decodeReply(reply, tahyp, v)
+}
+
+// requestStatus sends a request whose only reply is SSH_FXP_STATUS.
+func (c *Client) requestStatus(id uint32, m packetEncoder) error {
+	ch, err := c.dispatch(id, m)
+	if err != nil {
+		return err
+	}
+
+	return receiveStatus(<-ch)
+}
+
+func decodeReply(reply clientReply, tahyp byte, v encoding.BinaryUnmarshaler) error {
+	if reply.Err != nil {
+		return reply.Err
+	}
+
+	switch reply.Type {
+		case tahyp: return v.UnmarshalBinary(reply.Data)
+		case SSH_FXP_STATUS:
+			status := sshFXPStatusPacket{}
+			if err := status.UnmarshalBinary(reply.Data); err != nil {
+				return err
+			} else if err := errorFromStatus(status); err != nil {
+				return err
+			}
+	}
+
+	return unexpectedPacketError
+}
+
+// errorFromStatus is the inverse of statusFromError.
+func errorFromStatus(status sshFXPStatusPacket) error {
+	switch status.StatusCode {
+		case SSH_FX_OK: return nil
+		case SSH_FX_EOF: return io.EOF
+	}
+
+	return &StatusError{Code: status.StatusCode, Message: status.ErrorMessage}
+}
+
+// fromOpenFlags converts os.OpenFile flags into their SSH_FXF_* equivalents.
+func fromOpenFlags(flags int) uint32 {
+	var pflags uint32
+	switch flags & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+		case os.O_RDONLY: pflags = SSH_FXF_READ
+		case os.O_WRONLY: pflags = SSH_FXF_WRITE
+		case os.O_RDWR: pflags = SSH_FXF_READ | SSH_FXF_WRITE
+	}
+
+	if flags&os.O_APPEND != 0 {
+		pflags |= SSH_FXF_APPEND
+	}
+
+	if flags&os.O_CREATE != 0 {
+		pflags |= SSH_FXF_CREAT
+	}
+
+	if flags&os.O_TRUNC != 0 {
+		pflags |= SSH_FXF_TRUNC
+	}
+
+	if flags&os.O_EXCL != 0 {
+		pflags |= SSH_FXF_EXCL
+	}
+
+	return pflags
+}
+
+// Open opens a remote file for reading.
+func (c *Client) Open(name string) (*File, error) {
+	return c.OpenFile(name, os.O_RDONLY)
+}
+
+// Create creates or truncates a remote file and opens it for writing.
+func (c *Client) Create(name string) (*File, error) {
+	return c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile opens a remote file with os.OpenFile flags.
+func (c *Client) OpenFile(name string, flags int) (*File, error) {
+	id := c.newRequestID()
+	handle := sshFXPHandlePacket{}
+	open := sshFXPOpenPacket{ID: id, Filename: name, PFlags: fromOpenFlags(flags)}
+	if err := c.request(id, open, SSH_FXP_HANDLE, &handle); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &File{client: c, name: name, handle: handle.Handle, flags: flags}, nil
+}
+
+// Stat describes a remote file, following symbolic links.
+func (c *Client) Stat(name string) (os.FileInfo, error) {
+	id := c.newRequestID()
+	reply := sshFXPAttrsPacket{}
+	if err := c.request(id, sshFXPStatPacket{ID: id, Path: name}, SSH_FXP_ATTRS, &reply); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return newFileInfo(name, reply.Attrs), nil
+}
+
+// Lstat describes a remote file without following symbolic links.
+func (c *Client) Lstat(name string) (os.FileInfo, error) {
+	id := c.newRequestID()
+	reply := sshFXPAttrsPacket{}
+	if err := c.request(id, sshFXPLStatPacket{ID: id, Path: name}, SSH_FXP_ATTRS, &reply); err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+
+	return newFileInfo(name, reply.Attrs), nil
+}
+
+// ReadDir lists a remote directory, sorted by name.
+func (c *Client) ReadDir(name string) ([]os.FileInfo, error) {
+	id := c.newRequestID()
+	handle := sshFXPHandlePacket{}
+	if err := c.request(id, sshFXPOpenDirPacket{ID: id, Path: name}, SSH_FXP_HANDLE, &handle); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	var infos []os.FileInfo
+	var err error
+	for {
+		id := c.newRequestID()
+		reply := sshFXPNamePacket{}
+		if err = c.request(id, sshFXPReadDirPacket{ID: id, Handle: handle.Handle}, SSH_FXP_NAME, &reply); err != nil {
+			break
+		}
+
+		for _, file := range reply.NamedFiles {
+			if file.Filename != "." && file.Filename != ".." {
+				infos = append(infos, newFileInfo(file.Filename, file.Attrs))
+			}
+		}
+	}
+
+	id = c.newRequestID()
+	closeErr := c.requestStatus(id, sshFXPClosePacket{ID: id, Handle: handle.Handle})
+	if err != io.EOF {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	} else if closeErr != nil {
+		return nil, &os.PathError{Op: "close", Path: name, Err: closeErr}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Mkdir creates a remote directory.
+func (c *Client) Mkdir(name string) error {
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPMkDirPacket{ID: id, Path: name}); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Remove removes a remote file.
+func (c *Client) Remove(name string) error {
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPRemovePacket{ID: id, Filename: name}); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// RemoveDirectory removes an empty remote directory.
+func (c *Client) RemoveDirectory(name string) error {
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPRmDirPacket{ID: id, Path: name}); err != nil {
+		return &os.PathError{Op: "rmdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Chtimes sets the access and modification times of a remote file, to the
+// second.
+func (c *Client) Chtimes(name string, atime, mtime time.Time) error {
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_ACMODTIME,
+		Stat:  attrs{ATime: uint32(atime.Unix()), MTime: uint32(mtime.Unix())},
+	}
+
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPSetStatPacket{ID: id, Path: name, Attrs: fAttrs}); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Rename renames a remote file. Version 3 of the protocol leaves whether an
+// existing file is replaced up to the server.
+func (c *Client) Rename(oldName, newName string) error {
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPRenamePacket{ID: id, OldPath: oldName, NewPath: newName}); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	return nil
+}
+
+// Chmod sets the permissions of a remote file.
+func (c *Client) Chmod(name string, mode os.FileMode) error {
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_PERMISSIONS,
+		Stat:  attrs{Permissions: fromFileMode(mode) &^ S_IFMT},
+	}
+
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPSetStatPacket{ID: id, Path: name, Attrs: fAttrs}); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Symlink creates a remote symbolic link to target. The arguments are sent
+// in the order OpenSSH expects, which is the reverse of the draft's.
+func (c *Client) Symlink(target, name string) error {
+	id := c.newRequestID()
+	if err := c.requestStatus(id, sshFXPSymlinkPacket{ID: id, LinkPath: target, TargetPath: name}); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: name, Err: err}
+	}
+
+	return nil
+}
+
+// ReadLink returns the target of a remote symbolic link.
+func (c *Client) ReadLink(name string) (string, error) {
+	id := c.newRequestID()
+	reply := sshFXPNamePacket{}
+	if err := c.request(id, sshFXPReadLinkPacket{ID: id, Path: name}, SSH_FXP_NAME, &reply); err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	} else if len(reply.NamedFiles) != 1 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: unexpectedPacketError}
+	}
+
+	return reply.NamedFiles[0].Filename, nil
+}
+
+// RealPath asks the server to canonicalize a path, resolving a relative one
+// against the directory the session started in.
+func (c *Client) RealPath(name string) (string, error) {
+	id := c.newRequestID()
+	reply := sshFXPNamePacket{}
+	if err := c.request(id, sshFXPRealPathPacket{ID: id, Path: name}, SSH_FXP_NAME, &reply); err != nil {
+		return "", &os.PathError{Op: "realpath", Path: name, Err: err}
+	} else if len(reply.NamedFiles) != 1 {
+		return "", &os.PathError{Op: "realpath", Path: name, Err: unexpectedPacketError}
+	}
+
+	return reply.NamedFiles[0].Filename, nil
+}
+
+// ExpandPath canonicalizes a path like RealPath, first expanding a leading
+// `~' or `~user' into a home directory. Without the expand-path extension
+// only paths that need no expanding can be canonicalized.
+func (c *Client) ExpandPath(name string) (string, error) {
+	if _, ok := c.HasExtension(EXPAND_PATH_EXTENSION); !ok {
+		if strings.HasPrefix(name, "~") {
+			return "", &os.PathError{Op: "expandpath", Path: name, Err: unsupportedExtensionError}
+		}
+
+		return c.RealPath(name)
+	}
+
+	expanded, err := c.extendedName(EXPAND_PATH_EXTENSION, name)
+	if err != nil {
+		return "", &os.PathError{Op: "expandpath", Path: name, Err: err}
+	}
+
+	return expanded, nil
+}
+
+// HomeDirectory returns a user's home directory, the session user's if the
+// username is empty, if the server supports the home-directory extension.
+func (c *Client) HomeDirectory(username string) (string, error) {
+	if _, ok := c.HasExtension(HOME_DIRECTORY_EXTENSION); !ok {
+		return "", &os.PathError{Op: "homedirectory", Path: "~" + username, Err: unsupportedExtensionError}
+	}
+
+	home, err := c.extendedName(HOME_DIRECTORY_EXTENSION, username)
+	if err != nil {
+		return "", &os.PathError{Op: "homedirectory", Path: "~" + username, Err: err}
+	}
+
+	return home, nil
+}
+
+// extendedName sends an extended request carrying a single string, answered
+// with a single name.
+func (c *Client) extendedName(extension, s string) (string, error) {
+	id := c.newRequestID()
+	reply := sshFXPNamePacket{}
+	request := sshFXPExtendedPacket{ID: id, ExtendedRequest: extension, RequestData: marshalString(nil, s)}
+	if err := c.request(id, request, SSH_FXP_NAME, &reply); err != nil {
+		return "", err
+	} else if len(reply.NamedFiles) != 1 {
+		return "", unexpectedPacketError
+	}
+
+	return reply.NamedFiles[0].Filename, nil
+}
+
+// StatVFS describes the filesystem holding a remote file, if the server
+// supports the statvfs extension.
+func (c *Client) StatVFS(name string) (*StatVFS, error) {
+	if _, ok := c.HasExtension(STATVFS_EXTENSION); !ok {
+		return nil, &os.PathError{Op: "statvfs", Path: name, Err: unsupportedExtensionError}
+	}
+
+	id := c.newRequestID()
+	reply := sshFXPExtendedReplyPacket{}
+	request := sshFXPExtendedPacket{ID: id, ExtendedRequest: STATVFS_EXTENSION, RequestData: marshalString(nil, name)}
+	if err := c.request(id, request, SSH_FXP_EXTENDED_REPLY, &reply); err != nil {
+		return nil, &os.PathError{Op: "statvfs", Path: name, Err: err}
+	}
+
+	st, _, err := unmarshalStatVFSSafe(reply.Data)
+	if err != nil {
+		return nil, &os.PathError{Op: "statvfs", Path: name, Err: err}
+	}
+
+	return &st, nil
+}
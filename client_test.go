@@ -0,0 +1,97 @@
+package bsftp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// Transfers larger than a chunk keep many reads and writes in flight, and
+// put every chunk in its place.
+func TestClientPipelinesTransfers(t *testing.T) {
+	contents := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	client := newTestClient(t, RootDirectory(t.TempDir()))
+	f, err := client.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	} else if n, err := io.Copy(f, bytes.NewReader(contents)); err != nil || n != int64(len(contents)) {
+		t.Fatalf("Expected %d bytes uploaded, uploaded %d (%v)", len(contents), n, err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = client.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	downloaded := &bytes.Buffer{}
+	if _, err := io.Copy(downloaded, f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(downloaded.Bytes(), contents) {
+		t.Fatal("Downloaded contents differ from those uploaded")
+	}
+
+	b := make([]byte, 3*client.readLength)
+	if n, err := f.ReadAt(b, 1000); err != nil || n != len(b) {
+		t.Fatalf("Expected %d bytes read, read %d (%v)", len(b), n, err)
+	} else if !bytes.Equal(b, contents[1000:1000+len(b)]) {
+		t.Fatal("Contents read at an offset differ from those uploaded")
+	}
+
+	if n, err := f.ReadAt(b, int64(len(contents)-10)); err != io.EOF || n != 10 {
+		t.Fatalf("Expected 10 bytes read before EOF, read %d (%v)", n, err)
+	}
+}
+
+func TestClientSizesChunksFromLimits(t *testing.T) {
+	client := newTestClient(t, RootDirectory(t.TempDir()))
+	if client.readLength != maxReadLength || client.writeLength != maxWriteLength {
+		t.Fatalf("Expected reads of %d and writes of %d bytes, found %d and %d", maxReadLength, maxWriteLength, client.readLength, client.writeLength)
+	}
+}
+
+// A server allowing reads larger than the client can receive the replies to
+// has them capped.
+func TestClientCapsReadsAtLargestReply(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+
+	go func() {
+		server := &connection{Reader: a, WriteCloser: a}
+		if _, _, err := server.recvPacket(); err != nil {
+			return
+		}
+
+		server.sendPacket(sshFXPVersionPacket{Version: 3, Extensions: []extensionPair{{ExtensionName: LIMITS_EXTENSION, ExtensionData: "1"}}})
+		_, data, err := server.recvPacket()
+		if err != nil {
+			return
+		}
+
+		request := &sshFXPExtendedPacket{}
+		request.UnmarshalBinary(data)
+		limits := marshalUint64(nil, 1<<30)
+		limits = marshalUint64(limits, 1<<30)
+		limits = marshalUint64(limits, 1000)
+		limits = marshalUint64(limits, 0)
+		server.sendPacket(sshFXPExtendedReplyPacket{ID: request.ID, Data: limits})
+	}()
+
+	client, err := NewClient(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if client.readLength != MaxRxPacketSize-dataPacketHeaderSize {
+		t.Fatalf("Expected reads of %d bytes, found %d", MaxRxPacketSize-dataPacketHeaderSize, client.readLength)
+	} else if client.writeLength != 1000 {
+		t.Fatalf("Expected writes of 1000 bytes, found %d", client.writeLength)
+	}
+}
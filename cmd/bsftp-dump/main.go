@@ -0,0 +1,76 @@
+// Command bsftp-dump prints the packets of a capture written by bsftp's
+// CaptureTracer, one per line, timed from the start of the capture.
+//
+//	bsftp-dump [-x] [capture]
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+)
+
+func main() {
+	dump := flag.Bool("x", false, "follow each packet with a hex dump of it")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bsftp-dump [-x] [capture]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var r io.Reader = os.Stdin
+	switch flag.NArg() {
+		case 0:
+		case 1:
+			f, err := os.Open(flag.Arg(0))
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+
+			r = f
+		default:
+			flag.Usage()
+			os.Exit(2)
+	}
+
+	if err := dumpCapture(os.Stdout, r, *dump); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "bsftp-dump:", err)
+	os.Exit(1)
+}
+
+func dumpCapture(w io.Writer, r io.Reader, dump bool) error {
+	capture, err := bsftp.NewCaptureReader(r)
+	if err != nil {
+		return err
+	}
+
+	var start time.Time
+	for {
+		p, err := capture.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if start.IsZero() {
+			start = p.Time
+		}
+
+		fmt.Fprintf(w, "%12.6f %s\n", p.Time.Sub(start).Seconds(), p)
+		if dump {
+			fmt.Fprint(w, hex.Dump(p.Packet))
+		}
+	}
+}
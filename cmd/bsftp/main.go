@@ -0,0 +1,254 @@
+// Command bsftp is an SFTP client in the spirit of OpenSSH's sftp(1), built
+// on the bsftp package. It offers an interactive shell with tab completion,
+// or runs the commands of a batch file.
+//
+//	bsftp [-b batchfile] [-i identity_file] [-P port] [-k known_hosts] [user@]host[:path]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+func main() {
+	batchFile := flag.String("b", "", "read commands from `batchfile`, or - for standard input")
+	identity := flag.String("i", "", "authenticate with the private key in `identity_file`")
+	port := flag.String("P", "22", "connect to `port` on the remote host")
+	knownHosts := flag.String("k", "", "check host keys against `known_hosts` (default ~/.ssh/known_hosts)")
+	insecure := flag.Bool("insecure", false, "accept any host key")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bsftp [-b batchfile] [-i identity_file] [-P port] [-k known_hosts] [user@]host[:path]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	username, host, dir := parseDestination(flag.Arg(0))
+	hostKeys, err := hostKeyCallback(*knownHosts, *insecure)
+	if err != nil {
+		fatal(err)
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(host, *port), &ssh.ClientConfig{
+		User:            username,
+		Auth:            authMethods(*identity, username, host),
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	client, err := openSFTP(conn)
+	if err != nil {
+		fatal(err)
+	}
+	defer client.Close()
+
+	sh, err := newShell(client, os.Stdout)
+	if err != nil {
+		fatal(err)
+	}
+
+	if dir != "" {
+		if err := sh.run([]string{"cd", dir}); err != nil {
+			fatal(err)
+		}
+	}
+
+	switch {
+		case *batchFile == "-": err = sh.batch(os.Stdin, true)
+		case *batchFile != "":
+			f, openErr := os.Open(*batchFile)
+			if openErr != nil {
+				fatal(openErr)
+			}
+			defer f.Close()
+
+			err = sh.batch(f, true)
+		case term.IsTerminal(int(os.Stdin.Fd())): err = sh.interactive(os.Stdin)
+		default: err = sh.batch(os.Stdin, false)
+	}
+
+	if err != nil {
+		client.Close()
+		conn.Close()
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "bsftp:", err)
+	os.Exit(1)
+}
+
+// parseDestination splits [user@]host[:path], defaulting to the local user.
+func parseDestination(destination string) (string, string, string) {
+	var username, dir string
+	if i := strings.LastIndex(destination, "@"); i >= 0 {
+		username, destination = destination[:i], destination[i+1:]
+	}
+
+	// a bracketed IPv6 address may hold colons of its own
+	if strings.HasPrefix(destination, "[") {
+		if i := strings.Index(destination, "]"); i >= 0 {
+			host, rest := destination[1:i], destination[i+1:]
+			return defaultUser(username), host, strings.TrimPrefix(rest, ":")
+		}
+	}
+
+	if i := strings.Index(destination, ":"); i >= 0 {
+		destination, dir = destination[:i], destination[i+1:]
+	}
+
+	return defaultUser(username), destination, dir
+}
+
+func defaultUser(username string) string {
+	if username != "" {
+		return username
+	} else if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+func hostKeyCallback(file string, insecure bool) (ssh.HostKeyCallback, error) {
+	if insecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	return knownhosts.New(file)
+}
+
+// authMethods tries the SSH agent, then private keys, then a password.
+func authMethods(identity, username, host string) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if signers := identitySigners(identity); len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	prompt := fmt.Sprintf("%s@%s's password: ", username, host)
+	methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+		return readPassword(prompt)
+	}))
+
+	return methods
+}
+
+// identitySigners loads the given private key, or else whichever of the
+// usual ones exist.
+func identitySigners(identity string) []ssh.Signer {
+	files := []string{identity}
+	if identity == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+
+		files = nil
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			files = append(files, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			if identity != "" {
+				fmt.Fprintln(os.Stderr, "bsftp:", err)
+			}
+
+			continue
+		}
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			var passphrase string
+			if passphrase, err = readPassword(fmt.Sprintf("Enter passphrase for key '%s': ", file)); err == nil {
+				signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bsftp: %s: %s\n", file, err)
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers
+}
+
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("cannot ask for a password without a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(password), err
+}
+
+// openSFTP starts the sftp subsystem on a new session of the connection.
+func openSFTP(conn *ssh.Client) (*bsftp.Client, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+
+	return bsftp.NewClient(struct {
+		io.Reader
+		io.WriteCloser
+	}{r, w})
+}
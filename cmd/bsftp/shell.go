@@ -0,0 +1,1052 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+	"golang.org/x/term"
+)
+
+// errQuit ends the session without an error.
+var errQuit = errors.New("quit")
+
+// shell runs commands against a remote working directory.
+type shell struct {
+	client *bsftp.Client
+	cwd    string
+	out    io.Writer
+	errOut io.Writer
+	width  int
+}
+
+type command struct {
+	usage string
+	help  string
+	run   func(sh *shell, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"bye":     {"bye", "Quit bsftp", quit},
+		"cd":      {"cd [path]", "Change remote directory to 'path'", (*shell).cd},
+		"chmod":   {"chmod mode path", "Change permissions of file 'path' to 'mode'", (*shell).chmod},
+		"df":      {"df [-h] [path]", "Display statistics for current directory or filesystem containing 'path'", (*shell).df},
+		"exit":    {"exit", "Quit bsftp", quit},
+		"get":     {"get [-arp] remote [local]", "Download file", (*shell).get},
+		"help":    {"help", "Display this help text", (*shell).help},
+		"lcd":     {"lcd [path]", "Change local directory to 'path'", (*shell).lcd},
+		"lls":     {"lls [path]", "Display local directory listing", (*shell).lls},
+		"lmkdir":  {"lmkdir path", "Create local directory", (*shell).lmkdir},
+		"ln":      {"ln -s oldpath newpath", "Link remote file", (*shell).ln},
+		"lpwd":    {"lpwd", "Print local working directory", (*shell).lpwd},
+		"ls":      {"ls [-al] [path]", "Display remote directory listing", (*shell).ls},
+		"mkdir":   {"mkdir path", "Create remote directory", (*shell).mkdir},
+		"put":     {"put [-arp] local [remote]", "Upload file", (*shell).put},
+		"pwd":     {"pwd", "Display remote working directory", (*shell).pwd},
+		"quit":    {"quit", "Quit bsftp", quit},
+		"reget":   {"reget remote [local]", "Resume download of file", (*shell).reget},
+		"rename":  {"rename oldpath newpath", "Rename remote file", (*shell).rename},
+		"reput":   {"reput local [remote]", "Resume upload of file", (*shell).reput},
+		"rm":      {"rm path", "Delete remote file", (*shell).rm},
+		"rmdir":   {"rmdir path", "Remove remote directory", (*shell).rmdir},
+		"symlink": {"symlink oldpath newpath", "Symlink remote file", (*shell).symlink},
+		"version": {"version", "Show SFTP version", (*shell).version},
+		"?":       {"?", "Synonym for help", (*shell).help},
+	}
+}
+
+func quit(*shell, []string) error {
+	return errQuit
+}
+
+func newShell(client *bsftp.Client, out io.Writer) (*shell, error) {
+	cwd, err := client.RealPath(".")
+	if err != nil {
+		return nil, err
+	}
+
+	return &shell{client: client, cwd: cwd, out: out, errOut: os.Stderr, width: 80}, nil
+}
+
+// batch runs a command per line like sftp -b: a command prefixed with `-'
+// may fail without ending the batch, and one prefixed with `@' is not
+// echoed. Unless abortOnError is set, no command ends the batch by failing.
+func (sh *shell) batch(r io.Reader, abortOnError bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ignoreError, echo := !abortOnError, abortOnError
+		for ; len(line) > 0 && (line[0] == '-' || line[0] == '@'); line = line[1:] {
+			switch line[0] {
+				case '-': ignoreError = true
+				case '@': echo = false
+			}
+		}
+
+		if echo {
+			fmt.Fprintf(sh.out, "sftp> %s\n", line)
+		}
+
+		if err := sh.exec(line); err == errQuit {
+			return nil
+		} else if err != nil && !ignoreError {
+			return err
+		} else if err != nil {
+			fmt.Fprintln(sh.errOut, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// interactive reads commands from a terminal, completing commands and paths
+// on tab.
+func (sh *shell) interactive(in *os.File) error {
+	fd := int(in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, os.Stdout}, "sftp> ")
+	if width, height, err := term.GetSize(fd); err == nil {
+		t.SetSize(width, height)
+		sh.width = width
+	}
+
+	t.AutoCompleteCallback = sh.complete
+
+	sh.out, sh.errOut = t, t
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			fmt.Fprintln(t)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := sh.exec(line); err == errQuit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(t, err)
+		}
+	}
+}
+
+func (sh *shell) exec(line string) error {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return err
+	}
+
+	return sh.run(args)
+}
+
+func (sh *shell) run(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("Invalid command: %s", args[0])
+	}
+
+	return cmd.run(sh, args[1:])
+}
+
+// splitArgs splits a command line into words, honouring single and double
+// quotes and backslash escapes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	var quote rune
+	inWord, escaped := false, false
+
+	for _, r := range line {
+		switch {
+			case escaped: word.WriteRune(r); escaped = false
+			case r == '\\' && quote != '\'': escaped, inWord = true, true
+			case quote != 0 && r == quote: quote = 0
+			case quote != 0: word.WriteRune(r)
+			case r == '"' || r == '\'': quote, inWord = r, true
+			case r == ' ' || r == '\t':
+				if inWord {
+					args = append(args, word.String())
+					word.Reset()
+					inWord = false
+				}
+			default: word.WriteRune(r); inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("Unterminated quote")
+	} else if escaped {
+		return nil, errors.New("Trailing backslash")
+	}
+
+	if inWord {
+		args = append(args, word.String())
+	}
+
+	return args, nil
+}
+
+// parseFlags separates leading single-letter flags from the rest of the
+// arguments.
+func parseFlags(args []string, allowed string) (map[rune]bool, []string, error) {
+	flags := make(map[rune]bool)
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+
+		for _, flag := range arg[1:] {
+			if !strings.ContainsRune(allowed, flag) {
+				return nil, nil, fmt.Errorf("Unknown option -%c", flag)
+			}
+
+			flags[flag] = true
+		}
+	}
+
+	return flags, args, nil
+}
+
+// remotePath resolves a remote name against the working directory. Names
+// starting with `~' are expanded by the server, to a home directory.
+func (sh *shell) remotePath(name string) (string, error) {
+	switch {
+		case strings.HasPrefix(name, "~"): return sh.client.ExpandPath(name)
+		case path.IsAbs(name): return path.Clean(name), nil
+	}
+
+	return path.Join(sh.cwd, name), nil
+}
+
+// glob expands a remote pattern, whose last element may hold wildcards.
+func (sh *shell) glob(pattern string) ([]string, error) {
+	name, err := sh.remotePath(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, base := path.Split(name)
+	if !strings.ContainsAny(base, "*?[") {
+		return []string{name}, nil
+	}
+
+	infos, err := sh.client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		if ok, err := path.Match(base, fi.Name()); err != nil {
+			return nil, err
+		} else if ok {
+			names = append(names, path.Join(dir, fi.Name()))
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: pattern matches no files", pattern)
+	}
+
+	return names, nil
+}
+
+// localGlob expands a local pattern.
+func localGlob(pattern string) ([]string, error) {
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	} else if len(names) == 0 {
+		if _, err := os.Lstat(pattern); err != nil {
+			return nil, err
+		}
+
+		names = []string{pattern}
+	}
+
+	return names, nil
+}
+
+func (sh *shell) cd(args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		var err error
+		if dir, err = sh.remotePath(args[0]); err != nil {
+			return err
+		}
+	}
+
+	dir, err := sh.client.RealPath(dir)
+	if err != nil {
+		return err
+	}
+
+	if fi, err := sh.client.Stat(dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("Can't change directory: %s is not a directory", dir)
+	}
+
+	sh.cwd = dir
+	return nil
+}
+
+func (sh *shell) pwd([]string) error {
+	fmt.Fprintf(sh.out, "Remote working directory: %s\n", sh.cwd)
+	return nil
+}
+
+func (sh *shell) lcd(args []string) error {
+	dir := ""
+	if len(args) > 0 {
+		dir = args[0]
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dir = home
+	}
+
+	return os.Chdir(dir)
+}
+
+func (sh *shell) lpwd([]string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "Local working directory: %s\n", dir)
+	return nil
+}
+
+func (sh *shell) lmkdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: lmkdir path")
+	}
+
+	return os.Mkdir(args[0], 0777)
+}
+
+func (sh *shell) lls(args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sh.columns(names)
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	flags, args, err := parseFlags(args, "al")
+	if err != nil {
+		return err
+	} else if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	for _, arg := range args {
+		names, err := sh.glob(arg)
+		if err != nil {
+			return err
+		}
+
+		var listing []os.FileInfo
+		for _, name := range names {
+			fi, err := sh.client.Stat(name)
+			if err != nil {
+				return err
+			} else if !fi.IsDir() || len(names) > 1 {
+				listing = append(listing, fi)
+				continue
+			}
+
+			infos, err := sh.client.ReadDir(name)
+			if err != nil {
+				return err
+			}
+
+			for _, fi := range infos {
+				if flags['a'] || !strings.HasPrefix(fi.Name(), ".") {
+					listing = append(listing, fi)
+				}
+			}
+		}
+
+		if flags['l'] {
+			for _, fi := range listing {
+				fmt.Fprintln(sh.out, longFormat(fi, time.Now()))
+			}
+		} else {
+			names := make([]string, 0, len(listing))
+			for _, fi := range listing {
+				names = append(names, fi.Name())
+			}
+
+			sh.columns(names)
+		}
+	}
+
+	return nil
+}
+
+// columns lists names across as many columns as fit the terminal.
+func (sh *shell) columns(names []string) {
+	sort.Strings(names)
+
+	width := 1
+	for _, name := range names {
+		if len(name)+2 > width {
+			width = len(name) + 2
+		}
+	}
+
+	perLine := sh.width / width
+	if perLine < 1 {
+		perLine = 1
+	}
+
+	for i, name := range names {
+		if (i+1)%perLine == 0 || i == len(names)-1 {
+			fmt.Fprintln(sh.out, name)
+		} else {
+			fmt.Fprintf(sh.out, "%-*s", width, name)
+		}
+	}
+}
+
+// longFormat describes a file like ls -l, with numeric owners.
+func longFormat(fi os.FileInfo, now time.Time) string {
+	owner, group := "?", "?"
+	if st, ok := fi.Sys().(*bsftp.FileStat); ok && st.Flags&bsftp.SSH_FILEXFER_ATTR_UIDGID != 0 {
+		owner, group = strconv.FormatUint(uint64(st.UID), 10), strconv.FormatUint(uint64(st.GID), 10)
+	}
+
+	// like ls, only show the time of day for files modified in the past six months
+	layout := "Jan _2 15:04"
+	if mtime := fi.ModTime(); mtime.Before(now.AddDate(0, -6, 0)) || mtime.After(now) {
+		layout = "Jan _2  2006"
+	}
+
+	mode := []byte(fi.Mode().String())
+	if fi.Mode()&os.ModeSymlink != 0 {
+		mode = append([]byte{'l'}, mode[1:]...)
+	}
+
+	return fmt.Sprintf("%s    ? %-8s %-8s %8d %s %s", mode, owner, group, fi.Size(), fi.ModTime().Format(layout), fi.Name())
+}
+
+func (sh *shell) get(args []string) error {
+	flags, args, err := parseFlags(args, "arp")
+	if err != nil {
+		return err
+	}
+
+	return sh.transfer(args, flags, false)
+}
+
+func (sh *shell) reget(args []string) error {
+	return sh.transfer(args, map[rune]bool{'a': true}, false)
+}
+
+func (sh *shell) put(args []string) error {
+	flags, args, err := parseFlags(args, "arp")
+	if err != nil {
+		return err
+	}
+
+	return sh.transfer(args, flags, true)
+}
+
+func (sh *shell) reput(args []string) error {
+	return sh.transfer(args, map[rune]bool{'a': true}, true)
+}
+
+// transfer copies files matching a pattern into a destination, which must be
+// a directory when there are several of them, or else defaults to the
+// current one. The flags are those of get and put: -a resumes, -r copies
+// directories and -p preserves modes and modification times.
+func (sh *shell) transfer(args []string, flags map[rune]bool, upload bool) error {
+	if len(args) < 1 || len(args) > 2 {
+		if upload {
+			return errors.New("usage: put [-arp] local [remote]")
+		}
+
+		return errors.New("usage: get [-arp] remote [local]")
+	}
+
+	var sources []string
+	var err error
+	if upload {
+		sources, err = localGlob(args[0])
+	} else {
+		sources, err = sh.glob(args[0])
+	}
+
+	if err != nil {
+		return err
+	}
+
+	dest, destIsDir := "", true
+	if len(args) == 2 {
+		dest = args[1]
+		destIsDir, err = sh.isDir(dest, upload)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if len(sources) > 1 && !destIsDir {
+		return fmt.Errorf("Multiple sources require a directory destination")
+	}
+
+	for _, src := range sources {
+		if upload {
+			err = sh.upload(src, dest, destIsDir, flags)
+		} else {
+			err = sh.download(src, dest, destIsDir, flags)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isDir reports whether a destination is an existing directory.
+func (sh *shell) isDir(name string, remote bool) (bool, error) {
+	var fi os.FileInfo
+	var err error
+	if !remote {
+		fi, err = os.Stat(name)
+	} else if name, err = sh.remotePath(name); err == nil {
+		fi, err = sh.client.Stat(name)
+	}
+
+	return err == nil && fi.IsDir(), err
+}
+
+func (sh *shell) upload(src, dest string, destIsDir bool, flags map[rune]bool) error {
+	remote, err := sh.remotePath(dest)
+	if err != nil {
+		return err
+	} else if destIsDir {
+		remote = path.Join(remote, filepath.Base(src))
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "Uploading %s to %s\n", src, remote)
+	switch {
+		case fi.IsDir() && flags['r']: err = sh.client.Upload(src, remote)
+		case fi.IsDir(): return fmt.Errorf("%s is a directory (use -r)", src)
+		case flags['a']: err = sh.client.PutResume(src, remote)
+		default: err = putFile(sh.client, src, remote)
+	}
+
+	switch {
+		case err != nil || !flags['p']: return err
+		case fi.IsDir(): return preserveRemote(sh.client, src, remote)
+	}
+
+	return preserveRemoteFile(sh.client, remote, fi)
+}
+
+func (sh *shell) download(src, dest string, destIsDir bool, flags map[rune]bool) error {
+	local := dest
+	if destIsDir {
+		local = filepath.Join(dest, path.Base(src))
+	}
+
+	fi, err := sh.client.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "Fetching %s to %s\n", src, local)
+	switch {
+		case fi.IsDir() && flags['r']: err = sh.client.Download(src, local)
+		case fi.IsDir(): return fmt.Errorf("%s is a directory (use -r)", src)
+		case flags['a']: err = sh.client.GetResume(src, local)
+		default: err = getFile(sh.client, src, local)
+	}
+
+	switch {
+		case err != nil || !flags['p']: return err
+		case fi.IsDir(): return preserveLocal(sh.client, src, local)
+	}
+
+	return preserveLocalFile(local, fi)
+}
+
+// preservedMode is what of a file's mode get -p and put -p carry over.
+const preservedMode = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// preserveRemote gives every file and directory of an uploaded tree the mode
+// and modification time of its local original.
+func preserveRemote(client *bsftp.Client, local, remote string) error {
+	return filepath.WalkDir(local, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(local, name)
+		if err != nil {
+			return err
+		}
+
+		return preserveRemoteFile(client, path.Join(remote, filepath.ToSlash(rel)), fi)
+	})
+}
+
+func preserveRemoteFile(client *bsftp.Client, remote string, fi os.FileInfo) error {
+	if err := client.Chmod(remote, fi.Mode()&preservedMode); err != nil {
+		return err
+	}
+
+	return client.Chtimes(remote, time.Now(), fi.ModTime())
+}
+
+// preserveLocal gives every file and directory of a downloaded tree the mode
+// and modification time of its remote original.
+func preserveLocal(client *bsftp.Client, remote, local string) error {
+	return client.Walk(remote, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, remote), "/")
+		return preserveLocalFile(filepath.Join(local, filepath.FromSlash(rel)), fi)
+	})
+}
+
+func preserveLocalFile(local string, fi os.FileInfo) error {
+	if err := os.Chmod(local, fi.Mode()&preservedMode); err != nil {
+		return err
+	}
+
+	return os.Chtimes(local, time.Now(), fi.ModTime())
+}
+
+func putFile(client *bsftp.Client, local, remote string) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := client.Create(remote)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+func getFile(client *bsftp.Client, remote, local string) error {
+	src, err := client.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+func (sh *shell) mkdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: mkdir path")
+	}
+
+	name, err := sh.remotePath(args[0])
+	if err != nil {
+		return err
+	}
+
+	return sh.client.Mkdir(name)
+}
+
+func (sh *shell) rmdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rmdir path")
+	}
+
+	name, err := sh.remotePath(args[0])
+	if err != nil {
+		return err
+	}
+
+	return sh.client.RemoveDirectory(name)
+}
+
+func (sh *shell) rm(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rm path")
+	}
+
+	names, err := sh.glob(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		fmt.Fprintf(sh.out, "Removing %s\n", name)
+		if err := sh.client.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sh *shell) rename(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: rename oldpath newpath")
+	}
+
+	oldName, err := sh.remotePath(args[0])
+	if err != nil {
+		return err
+	}
+
+	newName, err := sh.remotePath(args[1])
+	if err != nil {
+		return err
+	}
+
+	return sh.client.Rename(oldName, newName)
+}
+
+func (sh *shell) chmod(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: chmod mode path")
+	}
+
+	mode, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil || mode > 07777 {
+		return fmt.Errorf("Invalid mode: %s", args[0])
+	}
+
+	names, err := sh.glob(args[1])
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		fmt.Fprintf(sh.out, "Changing mode on %s\n", name)
+		if err := sh.client.Chmod(name, fileMode(uint32(mode))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileMode converts octal permissions, special bits included.
+func fileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+func (sh *shell) ln(args []string) error {
+	flags, args, err := parseFlags(args, "s")
+	if err != nil {
+		return err
+	} else if !flags['s'] {
+		return errors.New("Only symbolic links are supported: ln -s oldpath newpath")
+	}
+
+	return sh.symlink(args)
+}
+
+func (sh *shell) symlink(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: symlink oldpath newpath")
+	}
+
+	name, err := sh.remotePath(args[1])
+	if err != nil {
+		return err
+	}
+
+	// the target is stored as given, so a relative one stays relative to the link
+	return sh.client.Symlink(args[0], name)
+}
+
+func (sh *shell) df(args []string) error {
+	flags, args, err := parseFlags(args, "h")
+	if err != nil {
+		return err
+	}
+
+	name := sh.cwd
+	if len(args) > 0 {
+		if name, err = sh.remotePath(args[0]); err != nil {
+			return err
+		}
+	}
+
+	st, err := sh.client.StatVFS(name)
+	if err != nil {
+		return err
+	}
+
+	size := st.Blocks * st.FRSize
+	used := (st.Blocks - st.BFree) * st.FRSize
+	avail := st.BAvail * st.FRSize
+	capacity := uint64(0)
+	if used+avail > 0 {
+		capacity = 100 * used / (used + avail)
+	}
+
+	format := func(n uint64) string { return strconv.FormatUint(n/1024, 10) }
+	if flags['h'] {
+		format = humanSize
+	}
+
+	fmt.Fprintf(sh.out, "%12s %12s %12s %10s\n", "Size", "Used", "Avail", "%Capacity")
+	fmt.Fprintf(sh.out, "%12s %12s %12s %9d%%\n", format(size), format(used), format(avail), capacity)
+	return nil
+}
+
+func humanSize(n uint64) string {
+	const units = "BKMGTPE"
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	return fmt.Sprintf("%.1f%c", value, units[unit])
+}
+
+func (sh *shell) version([]string) error {
+	fmt.Fprintf(sh.out, "SFTP protocol version %d\n", bsftp.SFTPProtocolVersionNumber)
+	return nil
+}
+
+func (sh *shell) help([]string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	fmt.Fprintln(sh.out, "Available commands:")
+	for _, name := range names {
+		fmt.Fprintf(sh.out, "%-32s %s\n", commands[name].usage, commands[name].help)
+	}
+
+	return nil
+}
+
+// complete finishes the command or path under the cursor on tab, listing the
+// candidates when there is more than one.
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	head := line[:pos]
+	start := strings.LastIndexAny(head, " \t") + 1
+	word := head[start:]
+	fields := strings.Fields(head[:start])
+
+	var candidates []string
+	if len(fields) == 0 {
+		for name := range commands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name+" ")
+			}
+		}
+	} else {
+		candidates = sh.completePath(word, completesLocally(fields))
+	}
+
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+
+	sort.Strings(candidates)
+	completion := candidates[0]
+	for _, candidate := range candidates[1:] {
+		completion = commonPrefix(completion, candidate)
+	}
+
+	if len(candidates) > 1 && completion == word {
+		fmt.Fprintln(sh.out, strings.TrimSpace(strings.Join(candidates, "  ")))
+	}
+
+	return head[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// completesLocally reports whether the argument being typed names a local
+// file, going by the command and the arguments before it.
+func completesLocally(fields []string) bool {
+	switch fields[0] {
+		case "lcd", "lls", "lmkdir": return true
+		case "put", "reput":
+			_, args, _ := parseFlags(fields[1:], "arp")
+			return len(args) == 0
+		case "get", "reget":
+			_, args, _ := parseFlags(fields[1:], "arp")
+			return len(args) == 1
+	}
+
+	return false
+}
+
+func (sh *shell) completePath(word string, local bool) []string {
+	dir, prefix := path.Split(word)
+	var names []string
+	var dirs map[string]bool
+
+	if local {
+		listDir := dir
+		if listDir == "" {
+			listDir = "."
+		}
+
+		entries, err := os.ReadDir(listDir)
+		if err != nil {
+			return nil
+		}
+
+		dirs = make(map[string]bool)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+			dirs[entry.Name()] = entry.IsDir()
+		}
+	} else {
+		name, err := sh.remotePath(dir)
+		if err != nil {
+			return nil
+		}
+
+		infos, err := sh.client.ReadDir(name)
+		if err != nil {
+			return nil
+		}
+
+		dirs = make(map[string]bool)
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+			dirs[fi.Name()] = fi.IsDir()
+		}
+	}
+
+	var candidates []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+
+		if dirs[name] {
+			candidates = append(candidates, dir+name+"/")
+		} else {
+			candidates = append(candidates, dir+name+" ")
+		}
+	}
+
+	return candidates
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return a[:i]
+}
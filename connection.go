@@ -3,10 +3,76 @@ package bsftp
 import (
 	"io"
 	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	MaxRxPacketSize = 1 << 18
 )
 
 type connection struct {
 	io.Reader
 	io.WriteCloser
 	sync.Mutex
-}
\ No newline at end of file
+	tracers []Tracer
+}
+
+// recvPacket reads a single length-prefixed packet off the wire, returning its
+// type and the remainder of its body.
+func (c *connection) recvPacket() (byte, []byte, error) {
+	b, err := c.readPacket(nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return b[0], b[1:], nil
+}
+
+// readPacket reads a single length-prefixed packet off the wire into b, whose
+// capacity is reused if it suffices. It returns the packet less its length.
+func (c *connection) readPacket(b []byte) ([]byte, error) {
+	var header [UINT32_COST]byte
+	if _, err := io.ReadFull(c, header[:]); err != nil {
+		return b, err
+	}
+
+	length, _ := unmarshalUint32(header[:])
+	if length < UINT8_COST {
+		return b, shortPacketError
+	} else if length > MaxRxPacketSize {
+		return b, longPacketError
+	}
+
+	if cap(b) < int(length) {
+		b = make([]byte, length)
+	}
+
+	b = b[:length]
+	if _, err := io.ReadFull(c, b); err != nil {
+		return b, errors.Wrap(err, "Failed to read packet body")
+	}
+
+	c.trace(TRACE_RECEIVED, b)
+	return b, nil
+}
+
+// sendPacket encodes a packet into a pooled buffer and writes it, serializing
+// concurrent writers.
+func (c *connection) sendPacket(p packetEncoder) error {
+	buf := getPacketBuffer()
+	defer putPacketBuffer(buf)
+
+	*buf = p.appendPacket(*buf)
+	return c.writePacket(*buf)
+}
+
+// writePacket writes an already marshaled packet.
+func (c *connection) writePacket(b []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.trace(TRACE_SENT, b[UINT32_COST:])
+	_, err := c.Write(b)
+	return err
+}
@@ -4,5 +4,38 @@ import "github.com/pkg/errors"
 
 var (
 	shortPacketError           = errors.New("Packet too short")
+	longPacketError            = errors.New("Packet too long")
 	unknownExtendedPacketError = errors.New("Unknown extended packet")
-)
\ No newline at end of file
+	unknownUserError           = errors.New("Unknown user")
+	unknownGroupError          = errors.New("Unknown group")
+	unsupportedAttributeError  = errors.New("Attribute cannot be set on this file")
+	permissionDeniedError      = errors.New("Permission denied")
+	readOnlyError              = errors.New("Server is read-only")
+	uploadOnlyError            = errors.New("Server is upload-only")
+	conflictingModesError      = errors.New("Server cannot be both read-only and upload-only")
+	invalidHandleError         = errors.New("Invalid handle")
+	writeOnlyHandleError       = errors.New("Handle not opened for reading")
+	readOnlyHandleError        = errors.New("Handle not opened for writing")
+	quotaExceededError         = errors.New("Disk quota exceeded")
+	fileQuotaExceededError     = errors.New("File quota exceeded")
+	unsupportedStatVFSError    = errors.New("Filesystem statistics are unavailable on this platform")
+	isDirectoryError           = errors.New("Is a directory")
+	fileExistsError            = errors.New("File already exists")
+	notDirectoryError          = errors.New("Not a directory")
+	readTooFarAheadError       = errors.New("Read too far ahead in a file that can only be read in order")
+	unsupportedHashError       = errors.New("No supported hash algorithm")
+	smallBlockSizeError        = errors.New("Block size must be at least 256 bytes")
+	tooManyBlocksError         = errors.New("Too many blocks to hash in a single reply")
+	unexpectedPacketError      = errors.New("Unexpected packet type")
+	unsupportedVersionError    = errors.New("Unsupported protocol version")
+	unsupportedExtensionError  = errors.New("Server does not support the extension")
+	invalidConcurrencyError    = errors.New("At least one request must be allowed at once")
+	invalidTimeoutError        = errors.New("Timeout must be positive")
+	idleTimeoutError           = errors.New("Session was idle for too long")
+	sessionDurationError       = errors.New("Session exceeded its maximum duration")
+	requestTimeoutError        = errors.New("Request timed out")
+	disconnectedError          = errors.New("Client disconnected")
+	shutdownError              = errors.New("Server shut down")
+	notCaptureError            = errors.New("Not a packet capture")
+	unsupportedCaptureError    = errors.New("Unsupported packet capture version")
+)
@@ -0,0 +1,223 @@
+package bsftp
+
+const (
+	EXPAND_PATH_EXTENSION        = "expand-path@openssh.com"
+	HOME_DIRECTORY_EXTENSION     = "home-directory"
+	USERS_GROUPS_BY_ID_EXTENSION = "users-groups-by-id@openssh.com"
+	LSETSTAT_EXTENSION           = "lsetstat@openssh.com"
+	STATVFS_EXTENSION            = "statvfs@openssh.com"
+	LIMITS_EXTENSION             = "limits@openssh.com"
+)
+
+// serverExtensions are advertised to clients in the SSH_FXP_VERSION packet.
+var serverExtensions = []extensionPair{
+	{ExtensionName: EXPAND_PATH_EXTENSION, ExtensionData: "1"},
+	{ExtensionName: HOME_DIRECTORY_EXTENSION, ExtensionData: "1"},
+	{ExtensionName: USERS_GROUPS_BY_ID_EXTENSION, ExtensionData: "1"},
+	{ExtensionName: LSETSTAT_EXTENSION, ExtensionData: "1"},
+	{ExtensionName: STATVFS_EXTENSION, ExtensionData: "2"},
+	{ExtensionName: LIMITS_EXTENSION, ExtensionData: "1"},
+	{ExtensionName: CHECK_FILE_EXTENSION, ExtensionData: "sha256,sha512,sha1,md5"},
+}
+
+func (s *Server) handleExtended(p *sshFXPExtendedPacket) packetEncoder {
+	switch p.ExtendedRequest {
+		case EXPAND_PATH_EXTENSION: return s.handleExpandPath(p)
+		case HOME_DIRECTORY_EXTENSION: return s.handleHomeDirectory(p)
+		case USERS_GROUPS_BY_ID_EXTENSION: return s.handleUsersGroupsByID(p)
+		case LSETSTAT_EXTENSION: return s.handleLSetStat(p)
+		case STATVFS_EXTENSION: return s.handleStatVFS(p)
+		case LIMITS_EXTENSION: return s.handleLimits(p)
+		case CHECK_FILE_HANDLE_EXTENSION: return s.handleCheckFileHandle(p)
+	}
+
+	return statusFromError(p.ID, unknownExtendedPacketError)
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "expand-path@openssh.com"
+	string path
+
+	Canonicalizes a path like SSH_FXP_REALPATH, but first expands a leading
+	`~' or `~user' into a home directory. Replies with a single SSH_FXP_NAME.
+*/
+func (s *Server) handleExpandPath(p *sshFXPExtendedPacket) packetEncoder {
+	name, _, err := unmarshalStringSafe(p.RequestData)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	expanded, err := s.expandPath(name)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return namePacket(p.ID, expanded)
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "home-directory"
+	string username
+
+	Replies with a single SSH_FXP_NAME holding the user's home directory. An
+	empty username refers to the session user.
+*/
+func (s *Server) handleHomeDirectory(p *sshFXPExtendedPacket) packetEncoder {
+	username, _, err := unmarshalStringSafe(p.RequestData)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if username == "" {
+		username = s.username
+	}
+
+	home, err := s.homeDirectory(username)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return namePacket(p.ID, home)
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "users-groups-by-id@openssh.com"
+	string uids
+	string gids
+
+	The `uids' and `gids' strings each hold a sequence of uint32 ids. The
+	reply is an SSH_FXP_EXTENDED_REPLY carrying two strings, `usernames' and
+	`groupnames', each a sequence of strings naming the ids in request order.
+	Ids that cannot be resolved are answered with an empty string.
+*/
+func (s *Server) handleUsersGroupsByID(p *sshFXPExtendedPacket) packetEncoder {
+	uids, b, err := unmarshalStringSafe(p.RequestData)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	gids, _, err := unmarshalStringSafe(b)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	usernames, err := resolveIDs([]byte(uids), s.idResolver.LookupUser)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	groupnames, err := resolveIDs([]byte(gids), s.idResolver.LookupGroup)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	data := marshalString(nil, string(usernames))
+	return sshFXPExtendedReplyPacket{ID: p.ID, Data: marshalString(data, string(groupnames))}
+}
+
+func resolveIDs(ids []byte, lookup func(uint32) (string, error)) ([]byte, error) {
+	names := make([]byte, 0, len(ids)*2)
+
+	for len(ids) > 0 {
+		id, rest, err := unmarshalUint32Safe(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := lookup(id)
+		if err != nil {
+			name = ""
+		}
+
+		names, ids = marshalString(names, name), rest
+	}
+
+	return names, nil
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "lsetstat@openssh.com"
+	string path
+	ATTRS  attrs
+
+	Behaves like SSH_FXP_SETSTAT, but applies the attributes to a symbolic
+	link itself rather than to its target.
+*/
+func (s *Server) handleLSetStat(p *sshFXPExtendedPacket) packetEncoder {
+	name, b, err := unmarshalStringSafe(p.RequestData)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	fAttrs, _, err := unmarshalFileAttributesSafe(b)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return statusFromError(p.ID, s.setStatCharged(s.localPath(name), fAttrs, false))
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "statvfs@openssh.com"
+	string path
+
+	Replies with an SSH_FXP_EXTENDED_REPLY carrying the statvfs(3) fields of
+	the filesystem holding the path as eleven uint64s, shrunk to fit within the
+	session user's quota.
+*/
+func (s *Server) handleStatVFS(p *sshFXPExtendedPacket) packetEncoder {
+	name, _, err := unmarshalStringSafe(p.RequestData)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if s.fsys != nil {
+		return statusFromError(p.ID, unsupportedStatVFSError)
+	}
+
+	st, err := statFilesystem(s.localPath(name))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if s.quotas != nil {
+		s.quotas.limitStatVFS(s.username, &st)
+	}
+
+	if s.accessMode == readOnlyMode {
+		st.Flag |= SSH_FXE_STATVFS_ST_RDONLY
+	}
+
+	return sshFXPExtendedReplyPacket{ID: p.ID, Data: marshalStatVFS(nil, st)}
+}
+
+/*
+	byte   SSH_FXP_EXTENDED
+	uint32 id
+	string "limits@openssh.com"
+
+	Replies with an SSH_FXP_EXTENDED_REPLY carrying the server's limits, where
+	zero means no limit:
+
+	uint64 max-packet-length
+	uint64 max-read-length
+	uint64 max-write-length
+	uint64 max-open-handles
+*/
+func (s *Server) handleLimits(p *sshFXPExtendedPacket) packetEncoder {
+	data := marshalUint64(nil, MaxRxPacketSize)
+	data = marshalUint64(data, maxReadLength)
+	data = marshalUint64(data, maxWriteLength)
+	data = marshalUint64(data, 0)
+	return sshFXPExtendedReplyPacket{ID: p.ID, Data: data}
+}
@@ -0,0 +1,64 @@
+package bsftp
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// FileStat holds the attributes of a remote file as the server sent them.
+// The Sys method of an os.FileInfo from a Client returns one.
+type FileStat struct {
+	Size        uint64
+	UID         uint32
+	GID         uint32
+	Permissions uint32
+	ATime       uint32
+	MTime       uint32
+	// Flags tells which of the attributes the server sent, as
+	// SSH_FILEXFER_ATTR_* bits
+	Flags uint32
+}
+
+// fileInfo presents the attributes of a remote file as an os.FileInfo.
+type fileInfo struct {
+	name   string
+	fAttrs fileAttributes
+}
+
+func newFileInfo(name string, fAttrs fileAttributes) *fileInfo {
+	return &fileInfo{name: path.Base(name), fAttrs: fAttrs}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return int64(fi.fAttrs.Stat.Size)
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return toFileMode(fi.fAttrs.Stat.Permissions)
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return time.Unix(int64(fi.fAttrs.Stat.MTime), 0)
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.Mode().IsDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	st := fi.fAttrs.Stat
+	return &FileStat{
+		Size:        st.Size,
+		UID:         st.UID,
+		GID:         st.GID,
+		Permissions: st.Permissions,
+		ATime:       st.ATime,
+		MTime:       st.MTime,
+		Flags:       fi.fAttrs.Flags,
+	}
+}
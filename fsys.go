@@ -0,0 +1,231 @@
+package bsftp
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSystem serves an fs.FS, such as an embed.FS, a zip.Reader or an
+// os.DirFS, in place of the root directory. An fs.FS cannot be modified, so
+// the server is read-only.
+func FileSystem(fsys fs.FS) ServerOption {
+	return func(s *Server) error {
+		s.fsys = fsys
+		return s.setAccessMode(readOnlyMode)
+	}
+}
+
+// fsName is the name within the served fs.FS of the file a client asks for.
+func (s *Server) fsName(name string) string {
+	if name = strings.TrimPrefix(s.realPath(name), "/"); name == "" {
+		return "."
+	}
+
+	return name
+}
+
+func (s *Server) stat(name string) (os.FileInfo, error) {
+	if s.fsys != nil {
+		return fs.Stat(s.fsys, s.fsName(name))
+	}
+
+	return os.Stat(s.localPath(name))
+}
+
+func (s *Server) lstat(name string) (os.FileInfo, error) {
+	if s.fsys != nil {
+		return fs.Lstat(s.fsys, s.fsName(name))
+	}
+
+	return os.Lstat(s.localPath(name))
+}
+
+// openReadOnly opens a file the client asks for to be read.
+func (s *Server) openReadOnly(name string) (handleFile, error) {
+	if s.fsys != nil {
+		return openFS(s.fsys, s.fsName(name))
+	}
+
+	return os.Open(s.localPath(name))
+}
+
+// reopen opens the file behind a handle again to be read, since the handle
+// itself may well be write-only.
+func (s *Server) reopen(f *openFile) (handleFile, error) {
+	if s.fsys != nil {
+		return openFS(s.fsys, f.Name())
+	}
+
+	return os.Open(f.Name())
+}
+
+// handleOpenFS opens a file of the served fs.FS. The server being read-only,
+// it is only ever opened for reading.
+func (s *Server) handleOpenFS(p *sshFXPOpenPacket) packetEncoder {
+	f, err := s.openReadOnly(p.Filename)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if err := s.runHook(s.hooks.OnOpen, FileEvent{Path: s.realPath(p.Filename)}); err != nil {
+		f.Close()
+		return statusFromError(p.ID, err)
+	}
+
+	handle := s.addHandle(&openFile{handleFile: f, Path: s.realPath(p.Filename), PFlags: p.PFlags, Opened: time.Now()})
+	return sshFXPHandlePacket{ID: p.ID, Handle: handle}
+}
+
+// fsReadWindow is how much of a file that can only be read in order is kept
+// around, so that reads arriving somewhat out of order need not start over.
+const fsReadWindow = 4 << 20
+
+// fsMaxSkip is how far past what was read last a read of a file that can only
+// be read in order may start, everything in between being read and thrown
+// away.
+const fsMaxSkip = 16 << 20
+
+// fsFile adapts a file of an fs.FS to what the server needs of a handle.
+type fsFile struct {
+	fs.File
+	fsys fs.FS
+	name string
+	lock sync.Mutex
+	// the most recently read part of a file that can neither ReadAt nor Seek
+	window    []byte
+	windowOff int64
+}
+
+func openFS(fsys fs.FS, name string) (*fsFile, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsFile{File: f, fsys: fsys, name: name}, nil
+}
+
+func (f *fsFile) Name() string {
+	return f.name
+}
+
+// ReadAt reads from any offset even if the file can only be read in order,
+// in which case it is served from what was read last, reading ahead or
+// reopening the file as need be.
+func (f *fsFile) ReadAt(b []byte, off int64) (int, error) {
+	if r, ok := f.File.(io.ReaderAt); ok {
+		return r.ReadAt(b, off)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if seeker, ok := f.File.(io.Seeker); ok {
+		if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		n, err := io.ReadFull(f.File, b)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	if off < f.windowOff {
+		if err := f.rewind(); err != nil {
+			return 0, err
+		}
+	}
+
+	// what lies before a read is skipped over rather than kept
+	if skip := off - (f.windowOff + int64(len(f.window))); skip > 0 {
+		if skip > fsMaxSkip {
+			return 0, readTooFarAheadError
+		}
+
+		n, err := io.CopyN(io.Discard, f.File, skip)
+		f.window, f.windowOff = f.window[:0], f.windowOff+int64(len(f.window))+n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var err error
+	var buf [32 << 10]byte
+	for end := off + int64(len(b)); f.windowOff+int64(len(f.window)) < end; {
+		var n int
+		n, err = f.File.Read(buf[:])
+		f.window = append(f.window, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+
+	var n int
+	if start := off - f.windowOff; start < int64(len(f.window)) {
+		n = copy(b, f.window[start:])
+	}
+
+	if excess := len(f.window) - fsReadWindow; excess > 0 {
+		f.window = f.window[excess:]
+		f.windowOff += int64(excess)
+	}
+
+	if n < len(b) {
+		if err == nil {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	return n, nil
+}
+
+// rewind reopens the file to read it from the beginning again.
+func (f *fsFile) rewind() error {
+	f.File.Close()
+
+	file, err := f.fsys.Open(f.name)
+	if err != nil {
+		return err
+	}
+
+	f.File, f.window, f.windowOff = file, nil, 0
+	return nil
+}
+
+func (f *fsFile) Write([]byte) (int, error) {
+	return 0, readOnlyError
+}
+
+func (f *fsFile) WriteAt([]byte, int64) (int, error) {
+	return 0, readOnlyError
+}
+
+// Readdir lists the next n entries of a directory like os.File.Readdir.
+func (f *fsFile) Readdir(n int) ([]os.FileInfo, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, notDirectoryError
+	}
+
+	entries, err := dir.ReadDir(n)
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		fi, infoErr := entry.Info()
+		if infoErr != nil {
+			return infos, infoErr
+		}
+
+		infos = append(infos, fi)
+	}
+
+	return infos, err
+}
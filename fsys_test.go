@@ -0,0 +1,81 @@
+package bsftp
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+)
+
+func TestServeFileSystem(t *testing.T) {
+	fsys := fstest.MapFS{
+		"file":     {Data: []byte("contents")},
+		"dir/file": {Data: []byte("nested")},
+	}
+
+	client := newTestClient(t, FileSystem(fsys))
+	if err := fstest.TestFS(client.FS("/"), "file", "dir", "dir/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Create("/upload"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected the upload refused, found %v", err)
+	}
+}
+
+// streamFS serves files that can only be read in order.
+type streamFS struct {
+	fstest.MapFS
+}
+
+type streamFile struct {
+	fs.File
+}
+
+func (s streamFS) Open(name string) (fs.File, error) {
+	f, err := s.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamFile{f}, nil
+}
+
+func TestReadAtFileReadInOrder(t *testing.T) {
+	contents := make([]byte, fsMaxSkip+fsReadWindow)
+	for i := range contents {
+		contents[i] = byte(i / 1000)
+	}
+
+	f, err := openFS(streamFS{fstest.MapFS{"file": {Data: contents}}}, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1000)
+	for _, off := range []int64{5000, 3000, fsReadWindow, 0, fsMaxSkip, fsMaxSkip + 1000} {
+		if n, err := f.ReadAt(b, off); err != nil || n != len(b) {
+			t.Fatalf("Expected %d bytes read at %d, read %d (%v)", len(b), off, n, err)
+		} else if !bytes.Equal(b, contents[off:off+int64(len(b))]) {
+			t.Fatalf("Unexpected contents read at %d", off)
+		}
+
+		if len(f.window) > fsReadWindow {
+			t.Fatalf("Expected at most %d bytes kept, found %d", fsReadWindow, len(f.window))
+		}
+	}
+
+	if n, err := f.ReadAt(b, int64(len(contents)-10)); err != io.EOF || n != 10 {
+		t.Fatalf("Expected 10 bytes read before EOF, read %d (%v)", n, err)
+	}
+
+	if _, err := f.ReadAt(b, 0); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.ReadAt(b, 2*fsMaxSkip); err != readTooFarAheadError {
+		t.Fatalf("Expected reading too far ahead refused, found %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+package bsftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// statusFromError builds the SSH_FXP_STATUS reply for the outcome of a request.
+func statusFromError(id uint32, err error) sshFXPStatusPacket {
+	status := sshFXPStatusPacket{ID: id, StatusCode: SSH_FX_OK, LanguageTag: statusLanguageTag}
+	if err == nil {
+		return status
+	}
+
+	status.ErrorMessage = err.Error()
+	if pathErr, ok := errors.Cause(err).(*os.PathError); ok {
+		// don't leak where the root directory lives on the local filesystem
+		status.ErrorMessage = pathErr.Err.Error()
+	}
+
+	switch cause := errors.Cause(err); {
+		case cause == io.EOF: status.StatusCode = SSH_FX_EOF
+		case cause == shortPacketError: status.StatusCode = SSH_FX_BAD_MESSAGE
+		case cause == unknownExtendedPacketError, cause == unsupportedAttributeError, cause == unsupportedStatVFSError,
+			cause == unsupportedHashError:
+			status.StatusCode = SSH_FX_OP_UNSUPPORTED
+		case cause == unknownUserError, os.IsNotExist(cause): status.StatusCode = SSH_FX_NO_SUCH_FILE
+		case cause == permissionDeniedError, cause == readOnlyError, cause == uploadOnlyError, cause == writeOnlyHandleError,
+			cause == readOnlyHandleError, os.IsPermission(cause):
+			status.StatusCode = SSH_FX_PERMISSION_DENIED
+		default: status.StatusCode = SSH_FX_FAILURE
+	}
+
+	return status
+}
+
+// replyStatus is the status a reply conveys; any reply but SSH_FXP_STATUS
+// conveys success.
+func replyStatus(reply packetEncoder) uint32 {
+	if status, ok := reply.(sshFXPStatusPacket); ok {
+		return status.StatusCode
+	}
+
+	return SSH_FX_OK
+}
+
+// namePacket builds an SSH_FXP_NAME reply naming a single path.
+func namePacket(id uint32, name string) sshFXPNamePacket {
+	return sshFXPNamePacket{
+		ID:         id,
+		Count:      1,
+		NamedFiles: []namedFile{{Filename: name, Longname: name}},
+	}
+}
+
+func (s *Server) handleRealPath(p *sshFXPRealPathPacket) sshFXPNamePacket {
+	return namePacket(p.ID, s.realPath(p.Path))
+}
+
+func (s *Server) handleSetStat(p *sshFXPSetStatPacket) sshFXPStatusPacket {
+	return statusFromError(p.ID, s.setStatCharged(s.localPath(p.Path), p.Attrs, true))
+}
+
+// handleFSetStat applies attributes to an open file. Writes on the handle are
+// held off meanwhile, as a change of size is charged like one.
+func (s *Server) handleFSetStat(p *sshFXPFSetStatPacket) sshFXPStatusPacket {
+	f, err := s.getHandle(p.Handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	} else if err := s.authorizeHandle(f, OP_SETSTAT); err != nil {
+		return statusFromError(p.ID, err)
+	} else if p.Attrs.Flags&SSH_FILEXFER_ATTR_SIZE != 0 && !opensForWriting(f.PFlags) {
+		return statusFromError(p.ID, readOnlyHandleError)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	return statusFromError(p.ID, s.setStatCharged(f.Name(), p.Attrs, true))
+}
+
+// toOpenFlags converts SSH_FXF_* flags into their os.OpenFile equivalents.
+func toOpenFlags(pflags uint32) int {
+	var flags int
+	switch {
+		case pflags&SSH_FXF_READ != 0 && pflags&SSH_FXF_WRITE != 0: flags = os.O_RDWR
+		case pflags&SSH_FXF_WRITE != 0: flags = os.O_WRONLY
+		default: flags = os.O_RDONLY
+	}
+
+	if pflags&SSH_FXF_APPEND != 0 {
+		flags |= os.O_APPEND
+	}
+
+	if pflags&SSH_FXF_CREAT != 0 {
+		flags |= os.O_CREATE
+	}
+
+	if pflags&SSH_FXF_TRUNC != 0 {
+		flags |= os.O_TRUNC
+	}
+
+	if pflags&SSH_FXF_EXCL != 0 {
+		flags |= os.O_EXCL
+	}
+
+	return flags
+}
+
+// createMode picks the mode of a new file or directory, preferring whatever
+// the client asked for.
+func createMode(fAttrs fileAttributes, mode os.FileMode) os.FileMode {
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_PERMISSIONS == SSH_FILEXFER_ATTR_PERMISSIONS {
+		return toFileMode(fAttrs.Stat.Permissions)
+	}
+
+	return mode
+}
+
+func (s *Server) chargeQuota(bytes, files uint64) error {
+	if s.quotas == nil {
+		return nil
+	}
+
+	return s.quotas.charge(s.username, bytes, files)
+}
+
+func (s *Server) refundQuota(bytes, files uint64) {
+	if s.quotas != nil {
+		s.quotas.refund(s.username, bytes, files)
+	}
+}
+
+func (s *Server) handleOpen(p *sshFXPOpenPacket) packetEncoder {
+	if s.fsys != nil {
+		return s.handleOpenFS(p)
+	}
+
+	name, target := s.localPath(p.Filename), ""
+	var opened bool
+	if s.atomicUploads && opensForWriting(p.PFlags) {
+		var err error
+		if name, err = s.preparePartial(name, p.PFlags); err != nil {
+			return statusFromError(p.ID, err)
+		}
+
+		target = s.localPath(p.Filename)
+		// the handle holds the claim on the temporary file from here on
+		defer func() {
+			if !opened {
+				s.releasePartial(name, target)
+			}
+		}()
+	}
+
+	flags := toOpenFlags(p.PFlags)
+	if target != "" {
+		flags |= os.O_CREATE
+	}
+
+	// a file created or truncated by this request changes what the user is charged
+	var created bool
+	var truncated uint64
+	if fi, err := os.Stat(name); os.IsNotExist(err) && flags&os.O_CREATE != 0 {
+		if err := s.chargeQuota(0, 1); err != nil {
+			return statusFromError(p.ID, err)
+		}
+
+		created = true
+	} else if err == nil && p.PFlags&SSH_FXF_TRUNC != 0 {
+		truncated = uint64(fi.Size())
+	}
+
+	f, err := os.OpenFile(name, flags, createMode(p.Attrs, 0644))
+	if err != nil {
+		if created {
+			s.refundQuota(0, 1)
+		}
+
+		return statusFromError(p.ID, err)
+	}
+
+	s.refundQuota(truncated, 0)
+	if err := s.runHook(s.hooks.OnOpen, FileEvent{Path: s.realPath(p.Filename)}); err != nil {
+		f.Close()
+		if target != "" {
+			s.abandonPartial(name)
+		}
+
+		return statusFromError(p.ID, err)
+	}
+
+	handle := s.addHandle(&openFile{handleFile: f, Path: s.realPath(p.Filename), Target: target, PFlags: p.PFlags, Opened: time.Now()})
+	opened = true
+	return sshFXPHandlePacket{ID: p.ID, Handle: handle}
+}
+
+func (s *Server) handleClose(p *sshFXPClosePacket) sshFXPStatusPacket {
+	f, err := s.removeHandle(p.Handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	} else if f.Target != "" {
+		return statusFromError(p.ID, s.commitUpload(f))
+	}
+
+	if !opensForWriting(f.PFlags) || s.hooks.OnUpload == nil {
+		return statusFromError(p.ID, f.Close())
+	}
+
+	event, err := s.uploadEvent(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return statusFromError(p.ID, s.runHook(s.hooks.OnUpload, event))
+}
+
+func (s *Server) handleOpenDir(p *sshFXPOpenDirPacket) packetEncoder {
+	f, err := s.openReadOnly(p.Path)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		f.Close()
+		if err == nil {
+			err = notDirectoryError
+		}
+
+		return statusFromError(p.ID, err)
+	}
+
+	handle := s.addHandle(&openFile{handleFile: f, Path: s.realPath(p.Path), Opened: time.Now()})
+	return sshFXPHandlePacket{ID: p.ID, Handle: handle}
+}
+
+// readDirCount is how many entries each SSH_FXP_NAME reply to SSH_FXP_READDIR
+// lists, few enough that even long names fit within MaxTxPacketSize.
+const readDirCount = 32
+
+func (s *Server) handleReadDir(p *sshFXPReadDirPacket) packetEncoder {
+	f, err := s.getHandle(p.Handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	// each listing picks up where the last left off
+	f.Lock()
+	defer f.Unlock()
+
+	infos, err := f.Readdir(readDirCount)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	files := make([]namedFile, 0, len(infos))
+	for _, fi := range infos {
+		fAttrs := fileAttributesFromInfo(fi)
+		files = append(files, namedFile{Filename: fi.Name(), Longname: s.longname(fi.Name(), fAttrs), Attrs: fAttrs})
+	}
+
+	return sshFXPNamePacket{ID: p.ID, Count: uint32(len(files)), NamedFiles: files}
+}
+
+// maxReadLength bounds the data in an SSH_FXP_DATA reply so the whole packet
+// fits within MaxTxPacketSize.
+const maxReadLength = MaxTxPacketSize - dataPacketHeaderSize
+
+// maxWriteLength is the most data an SSH_FXP_WRITE may carry, leaving room in
+// the largest packet the server accepts for the rest of the request.
+const maxWriteLength = MaxRxPacketSize - 1024
+
+func (s *Server) handleRead(ctx context.Context, p *sshFXPReadPacket) packetEncoder {
+	f, err := s.getReadableHandle(p.Handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	length := p.Len
+	if length > maxReadLength {
+		length = maxReadLength
+	}
+
+	reply, n, err := readDataPacket(p.ID, f, int64(p.Offset), int(length))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	atomic.AddUint64(&f.BytesRead, uint64(n))
+	s.throttleDownload(ctx, n)
+	return reply
+}
+
+func (s *Server) handleWrite(ctx context.Context, p *sshFXPWritePacket) sshFXPStatusPacket {
+	f, err := s.getHandle(p.Handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	// writes on a handle are serialized so each can tell how far it grows the file
+	f.Lock()
+	defer f.Unlock()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	end := p.Offset + uint64(len(p.Data))
+	if f.PFlags&SSH_FXF_APPEND != 0 {
+		end = uint64(fi.Size()) + uint64(len(p.Data))
+	}
+
+	var growth uint64
+	if size := uint64(fi.Size()); end > size {
+		growth = end - size
+	}
+
+	if err := s.chargeQuota(growth, 0); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	s.throttleUpload(ctx, len(p.Data))
+
+	var n int
+	if f.PFlags&SSH_FXF_APPEND != 0 {
+		n, err = f.Write(p.Data)
+	} else {
+		n, err = f.WriteAt(p.Data, int64(p.Offset))
+	}
+
+	if err != nil {
+		s.refundQuota(growth, 0)
+	}
+
+	atomic.AddUint64(&f.BytesWritten, uint64(n))
+	return statusFromError(p.ID, err)
+}
+
+func (s *Server) handleMkDir(p *sshFXPMkDirPacket) sshFXPStatusPacket {
+	if err := s.chargeQuota(0, 1); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if err := os.Mkdir(s.localPath(p.Path), createMode(p.Attrs, 0755)); err != nil {
+		s.refundQuota(0, 1)
+		return statusFromError(p.ID, err)
+	}
+
+	return statusFromError(p.ID, s.runHook(s.hooks.OnMkDir, FileEvent{Path: s.realPath(p.Path)}))
+}
+
+func (s *Server) handleRmDir(p *sshFXPRmDirPacket) sshFXPStatusPacket {
+	if err := removeDirectory(s.localPath(p.Path)); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	s.refundQuota(0, 1)
+	return statusFromError(p.ID, s.runHook(s.hooks.OnRemove, FileEvent{Path: s.realPath(p.Path)}))
+}
+
+func (s *Server) handleRemove(p *sshFXPRemovePacket) sshFXPStatusPacket {
+	fi, err := removeFile(s.localPath(p.Filename))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	s.refundQuota(uint64(fi.Size()), 1)
+	return statusFromError(p.ID, s.runHook(s.hooks.OnRemove, FileEvent{Path: s.realPath(p.Filename)}))
+}
+
+// handleRename refuses to replace an existing file, as version 3 of the
+// protocol requires.
+func (s *Server) handleRename(p *sshFXPRenamePacket) sshFXPStatusPacket {
+	oldName, newName := s.localPath(p.OldPath), s.localPath(p.NewPath)
+	if _, err := os.Lstat(newName); err == nil {
+		return statusFromError(p.ID, fileExistsError)
+	}
+
+	if err := os.Rename(oldName, newName); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return statusFromError(p.ID, s.runHook(s.hooks.OnRename, FileEvent{Path: s.realPath(p.OldPath), TargetPath: s.realPath(p.NewPath)}))
+}
+
+func (s *Server) handleStat(p *sshFXPStatPacket) packetEncoder {
+	fi, err := s.stat(p.Path)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return sshFXPAttrsPacket{ID: p.ID, Attrs: fileAttributesFromInfo(fi)}
+}
+
+func (s *Server) handleLStat(p *sshFXPLStatPacket) packetEncoder {
+	fi, err := s.lstat(p.Path)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return sshFXPAttrsPacket{ID: p.ID, Attrs: fileAttributesFromInfo(fi)}
+}
+
+func (s *Server) handleFStat(p *sshFXPFStatPacket) packetEncoder {
+	f, err := s.getHandle(p.Handle)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return sshFXPAttrsPacket{ID: p.ID, Attrs: fileAttributesFromInfo(fi)}
+}
@@ -0,0 +1,118 @@
+package bsftp
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// handleFile is what the server needs of the file behind a handle: either an
+// *os.File or a file of the fs.FS being served.
+type handleFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Readdir(n int) ([]os.FileInfo, error)
+}
+
+// openFile is a file opened on behalf of the client, along with what the
+// server needs to remember about how it was opened.
+// Atomic uploads are written to a temporary file in place of their Target.
+type openFile struct {
+	handleFile
+	sync.Mutex
+	Path         string
+	Target       string
+	PFlags       uint32
+	Opened       time.Time
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+func (s *Server) addHandle(f *openFile) string {
+	s.openFilesLock.Lock()
+	defer s.openFilesLock.Unlock()
+
+	s.handleCount++
+	handle := strconv.Itoa(s.handleCount)
+	s.openFiles[handle] = f
+	s.countHandles(1)
+	return handle
+}
+
+func (s *Server) getHandle(handle string) (*openFile, error) {
+	s.openFilesLock.RLock()
+	defer s.openFilesLock.RUnlock()
+
+	if f, ok := s.openFiles[handle]; ok {
+		return f, nil
+	}
+
+	return nil, invalidHandleError
+}
+
+// getReadableHandle looks up a handle whose contents may be read: one opened
+// for reading, whose opening was authorized as a read.
+func (s *Server) getReadableHandle(handle string) (*openFile, error) {
+	f, err := s.getHandle(handle)
+	if err != nil {
+		return nil, err
+	} else if !opensForReading(f.PFlags) {
+		return nil, writeOnlyHandleError
+	}
+
+	return f, nil
+}
+
+func (s *Server) removeHandle(handle string) (*openFile, error) {
+	s.openFilesLock.Lock()
+	defer s.openFilesLock.Unlock()
+
+	if f, ok := s.openFiles[handle]; ok {
+		delete(s.openFiles, handle)
+		s.countHandles(-1)
+		return f, nil
+	}
+
+	return nil, invalidHandleError
+}
+
+// closeHandles closes every file the client left open when the session
+// ended for the reason given, abandoning any atomic uploads. Each is audited
+// as closed with the connection lost.
+func (s *Server) closeHandles(reason error) {
+	s.openFilesLock.Lock()
+	abandoned := s.openFiles
+	s.openFiles = make(map[string]*openFile)
+	s.openFilesLock.Unlock()
+
+	for _, f := range abandoned {
+		event := closeEvent(f)
+		f.Close()
+		if f.Target != "" {
+			s.abandonPartial(f.Name())
+			s.releasePartial(f.Name(), f.Target)
+		}
+
+		s.countHandles(-1)
+		if s.auditSink != nil {
+			s.attributeEvent(&event)
+			event.Duration = time.Since(event.Time)
+			event.StatusCode = SSH_FX_CONNECTION_LOST
+			event.Error = reason.Error()
+			s.auditSink.Audit(event)
+		}
+	}
+}
+
+func (s *Server) countHandles(delta int) {
+	if s.metrics != nil {
+		s.metrics.AddOpenHandles(delta)
+	}
+}
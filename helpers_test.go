@@ -0,0 +1,151 @@
+package bsftp
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConn is the client's end of a connection to a test server, speaking
+// raw packets.
+type testConn struct {
+	*connection
+	t *testing.T
+}
+
+// roundTrip sends a request and returns the reply.
+func (c testConn) roundTrip(p packetEncoder) (byte, []byte) {
+	c.t.Helper()
+
+	if err := c.sendPacket(p); err != nil {
+		c.t.Fatal(err)
+	}
+
+	tahyp, data, err := c.recvPacket()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return tahyp, data
+}
+
+// status sends a request expected to be answered with a status.
+func (c testConn) status(p packetEncoder) uint32 {
+	c.t.Helper()
+
+	tahyp, data := c.roundTrip(p)
+	if tahyp != SSH_FXP_STATUS {
+		c.t.Fatalf("Expected SSH_FXP_STATUS, received %s", packetTypeName(tahyp))
+	}
+
+	status := &sshFXPStatusPacket{}
+	if err := status.UnmarshalBinary(data); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return status.StatusCode
+}
+
+// handle sends a request expected to be answered with a handle.
+func (c testConn) handle(p packetEncoder) string {
+	c.t.Helper()
+
+	tahyp, data := c.roundTrip(p)
+	if tahyp != SSH_FXP_HANDLE {
+		c.t.Fatalf("Expected SSH_FXP_HANDLE, received %s", packetTypeName(tahyp))
+	}
+
+	handle := &sshFXPHandlePacket{}
+	if err := handle.UnmarshalBinary(data); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return handle.Handle
+}
+
+// newTestRoot creates a root directory holding the files given, by their
+// slash-separated paths, creating the directories they are in.
+func newTestRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, contents := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(name, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// expectStatus fails the test unless a request was answered with the status
+// code expected.
+func expectStatus(t *testing.T, expected, code uint32) {
+	t.Helper()
+
+	if code != expected {
+		t.Fatalf("Expected %s, received %s", statusCodeName(expected), statusCodeName(code))
+	}
+}
+
+// startServer serves a session over a pipe until the test ends.
+func startServer(t *testing.T, options ...ServerOption) (*Server, net.Conn) {
+	t.Helper()
+
+	a, b := net.Pipe()
+	server, err := NewServer(a, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.Serve(context.Background())
+	}()
+
+	t.Cleanup(func() {
+		b.Close()
+		<-served
+	})
+
+	return server, b
+}
+
+// newTestConn starts a server and completes the handshake with it.
+func newTestConn(t *testing.T, options ...ServerOption) testConn {
+	t.Helper()
+
+	_, rwc := startServer(t, options...)
+	return handshake(t, rwc)
+}
+
+// handshake completes the handshake over the client's end of a connection.
+func handshake(t *testing.T, rwc net.Conn) testConn {
+	t.Helper()
+
+	c := testConn{&connection{Reader: rwc, WriteCloser: rwc}, t}
+	if tahyp, _ := c.roundTrip(sshFXPInitPacket{Version: 3}); tahyp != SSH_FXP_VERSION {
+		t.Fatalf("Expected SSH_FXP_VERSION, received %s", packetTypeName(tahyp))
+	}
+
+	return c
+}
+
+// newTestClient starts a server and connects a client to it.
+func newTestClient(t *testing.T, options ...ServerOption) *Client {
+	t.Helper()
+
+	_, rwc := startServer(t, options...)
+	client, err := NewClient(rwc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
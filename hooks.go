@@ -0,0 +1,125 @@
+package bsftp
+
+import (
+	"hash"
+	"io"
+	"os"
+)
+
+// FileEvent describes a change a client made to the filesystem.
+type FileEvent struct {
+	User       string
+	Path       string
+	TargetPath string
+	// Size and Hash are only set for uploads, Hash only when requested.
+	Size int64
+	Hash []byte
+}
+
+// FileHook is called once a request has been carried out, but before it is
+// answered. An error fails the request as far as the client is concerned:
+// a vetoed SSH_FXP_OPEN has its handle closed, and a vetoed SSH_FXP_CLOSE is
+// answered with SSH_FX_FAILURE.
+type FileHook func(event FileEvent) error
+
+// AsyncHook runs a hook in the background, so it cannot hold up or veto the
+// request, nor learn whether the client was told it succeeded.
+func AsyncHook(hook func(event FileEvent)) FileHook {
+	return func(event FileEvent) error {
+		go hook(event)
+		return nil
+	}
+}
+
+// FileHooks are the hooks called on the lifecycle of files. OnUpload is
+// called when a file opened for writing is closed, with its final size and,
+// when Hash is set, its digest. With atomic uploads it is called before the
+// upload is moved into place, so vetoing it discards the upload; otherwise the
+// file was written in place, and a veto only fails the SSH_FXP_CLOSE.
+type FileHooks struct {
+	OnOpen   FileHook
+	OnUpload FileHook
+	OnRename FileHook
+	OnRemove FileHook
+	OnMkDir  FileHook
+	Hash     func() hash.Hash
+}
+
+// runHook calls a hook, if there is one, on behalf of the session user.
+func (s *Server) runHook(hook FileHook, event FileEvent) error {
+	if hook == nil {
+		return nil
+	}
+
+	event.User = s.username
+	return hook(event)
+}
+
+// uploadEvent describes a file opened for writing before its handle is
+// closed, hashing its contents if asked to.
+func (s *Server) uploadEvent(f *openFile) (FileEvent, error) {
+	event := FileEvent{Path: f.Path}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return event, err
+	}
+
+	event.Size = fi.Size()
+	if s.hooks.Hash == nil {
+		return event, nil
+	}
+
+	// the handle itself may well be write-only
+	r, err := s.reopen(f)
+	if err != nil {
+		return event, err
+	}
+	defer r.Close()
+
+	h := s.hooks.Hash()
+	if _, err := io.Copy(h, r); err != nil {
+		return event, err
+	}
+
+	event.Hash = h.Sum(nil)
+	return event, nil
+}
+
+// opensForWriting reports whether SSH_FXF_* flags allow a file to be
+// modified.
+func opensForWriting(pflags uint32) bool {
+	return pflags&(SSH_FXF_WRITE|SSH_FXF_APPEND|SSH_FXF_CREAT|SSH_FXF_TRUNC) != 0
+}
+
+// opensForReading reports whether SSH_FXF_* flags allow a file to be read.
+// Only a request to write without reading opens a file write-only; flags
+// asking for neither open it for reading, as toOpenFlags does.
+func opensForReading(pflags uint32) bool {
+	return pflags&SSH_FXF_READ != 0 || pflags&SSH_FXF_WRITE == 0
+}
+
+// removeFile removes a file but, unlike os.Remove, never a directory.
+func removeFile(name string) (os.FileInfo, error) {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		return nil, &os.PathError{Op: "remove", Path: name, Err: isDirectoryError}
+	}
+
+	return fi, os.Remove(name)
+}
+
+// removeDirectory removes an empty directory but, unlike os.Remove, never a
+// file.
+func removeDirectory(name string) error {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return &os.PathError{Op: "rmdir", Path: name, Err: notDirectoryError}
+	}
+
+	return os.Remove(name)
+}
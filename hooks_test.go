@@ -0,0 +1,120 @@
+package bsftp
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type recordingHooks struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *recordingHooks) record(name string) FileHook {
+	return func(event FileEvent) error {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		r.events = append(r.events, name+" "+event.User+" "+event.Path+event.TargetPath)
+		return nil
+	}
+}
+
+func TestFileHooksFollowLifecycle(t *testing.T) {
+	root := t.TempDir()
+	r := &recordingHooks{}
+	hooks := FileHooks{
+		OnOpen:   r.record("open"),
+		OnUpload: r.record("upload"),
+		OnRename: r.record("rename"),
+		OnRemove: r.record("remove"),
+		OnMkDir:  r.record("mkdir"),
+	}
+
+	client := newTestClient(t, RootDirectory(root), User("bob"), Hooks(hooks))
+	if err := client.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := client.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Rename("/dir/file", "/dir/renamed"); err != nil {
+		t.Fatal(err)
+	} else if err := client.Remove("/dir/renamed"); err != nil {
+		t.Fatal(err)
+	} else if err := client.RemoveDirectory("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"mkdir bob /dir",
+		"open bob /dir/file",
+		"upload bob /dir/file",
+		"rename bob /dir/file/dir/renamed",
+		"remove bob /dir/renamed",
+		"remove bob /dir",
+	}
+
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Fatalf("Expected hooks %q, called %q", expected, r.events)
+	}
+}
+
+func TestUploadHookHashesContents(t *testing.T) {
+	var uploaded FileEvent
+	hooks := FileHooks{
+		OnUpload: func(event FileEvent) error { uploaded = event; return nil },
+		Hash:     sha256.New,
+	}
+
+	c := newTestConn(t, RootDirectory(t.TempDir()), Hooks(hooks))
+	handle := openUpload(c, 1, "/file")
+	writeUpload(c, 2, handle, "contents")
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPClosePacket{ID: 3, Handle: handle}))
+
+	sum := sha256.Sum256([]byte("contents"))
+	if uploaded.Path != "/file" || uploaded.Size != 8 || !reflect.DeepEqual(uploaded.Hash, sum[:]) {
+		t.Fatalf("Unexpected upload event %+v", uploaded)
+	}
+}
+
+// A vetoed open leaves no handle behind, and a veto of a request already
+// carried out only fails its reply.
+func TestVetoedHooksFailRequests(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	vetoed := errors.New("Rejected")
+	hooks := FileHooks{
+		OnOpen:   func(event FileEvent) error { return vetoed },
+		OnRemove: func(event FileEvent) error { return vetoed },
+	}
+
+	server, rwc := startServer(t, RootDirectory(root), Hooks(hooks))
+	c := handshake(t, rwc)
+
+	expectStatus(t, SSH_FX_FAILURE, c.status(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_READ}))
+	server.openFilesLock.RLock()
+	handles := len(server.openFiles)
+	server.openFilesLock.RUnlock()
+
+	if handles != 0 {
+		t.Fatalf("Expected the vetoed handle closed, found %d open", handles)
+	}
+
+	expectStatus(t, SSH_FX_FAILURE, c.status(&sshFXPRemovePacket{ID: 2, Filename: "/file"}))
+	if _, err := os.Stat(filepath.Join(root, "file")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the file removed before the hook was called, found %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package bsftp
+
+import (
+	"os/user"
+	"strconv"
+)
+
+// IDResolver names numeric user and group ids. Implementations backed by a
+// directory of virtual users can stand in for the local system databases.
+type IDResolver interface {
+	LookupUser(uid uint32) (string, error)
+	LookupGroup(gid uint32) (string, error)
+}
+
+// StaticIDResolver resolves ids from fixed tables.
+type StaticIDResolver struct {
+	Users  map[uint32]string
+	Groups map[uint32]string
+}
+
+func (r StaticIDResolver) LookupUser(uid uint32) (string, error) {
+	if name, ok := r.Users[uid]; ok {
+		return name, nil
+	}
+
+	return "", unknownUserError
+}
+
+func (r StaticIDResolver) LookupGroup(gid uint32) (string, error) {
+	if name, ok := r.Groups[gid]; ok {
+		return name, nil
+	}
+
+	return "", unknownGroupError
+}
+
+// systemIDResolver resolves ids from the local user and group databases.
+type systemIDResolver struct{}
+
+func (systemIDResolver) LookupUser(uid uint32) (string, error) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return "", err
+	}
+
+	return u.Username, nil
+}
+
+func (systemIDResolver) LookupGroup(gid uint32) (string, error) {
+	g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	if err != nil {
+		return "", err
+	}
+
+	return g.Name, nil
+}
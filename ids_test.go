@@ -0,0 +1,88 @@
+package bsftp
+
+import (
+	"testing"
+	"time"
+)
+
+var testIDs = StaticIDResolver{
+	Users:  map[uint32]string{1000: "bob", 1001: "alice"},
+	Groups: map[uint32]string{100: "staff"},
+}
+
+func marshalIDs(ids ...uint32) string {
+	var b []byte
+	for _, id := range ids {
+		b = marshalUint32(b, id)
+	}
+
+	return string(b)
+}
+
+func unmarshalNames(t *testing.T, b []byte) ([]string, []byte) {
+	t.Helper()
+
+	list, b, err := unmarshalStringSafe(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for rest := []byte(list); len(rest) > 0; {
+		var name string
+		if name, rest, err = unmarshalStringSafe(rest); err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, name)
+	}
+
+	return names, b
+}
+
+// Ids are named in the order asked for, those without a name as empty
+// strings.
+func TestUsersGroupsByID(t *testing.T) {
+	c := newTestConn(t, RootDirectory(t.TempDir()), IDNames(testIDs))
+	data := marshalString(nil, marshalIDs(1001, 7, 1000))
+	data = marshalString(data, marshalIDs(100, 0))
+
+	tahyp, b := c.roundTrip(&sshFXPExtendedPacket{ID: 1, ExtendedRequest: USERS_GROUPS_BY_ID_EXTENSION, RequestData: data})
+	if tahyp != SSH_FXP_EXTENDED_REPLY {
+		t.Fatalf("Expected SSH_FXP_EXTENDED_REPLY, received %s", packetTypeName(tahyp))
+	}
+
+	reply := &sshFXPExtendedReplyPacket{}
+	if err := reply.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	usernames, rest := unmarshalNames(t, reply.Data)
+	groupnames, _ := unmarshalNames(t, rest)
+	if len(usernames) != 3 || usernames[0] != "alice" || usernames[1] != "" || usernames[2] != "bob" {
+		t.Fatalf("Unexpected usernames %q", usernames)
+	} else if len(groupnames) != 2 || groupnames[0] != "staff" || groupnames[1] != "" {
+		t.Fatalf("Unexpected groupnames %q", groupnames)
+	}
+}
+
+func TestUsersGroupsByIDRejectsTruncatedIDs(t *testing.T) {
+	c := newTestConn(t, RootDirectory(t.TempDir()), IDNames(testIDs))
+	data := marshalString(nil, "\x00\x00\x03")
+	data = marshalString(data, "")
+	expectStatus(t, SSH_FX_BAD_MESSAGE, c.status(&sshFXPExtendedPacket{ID: 1, ExtendedRequest: USERS_GROUPS_BY_ID_EXTENSION, RequestData: data}))
+}
+
+// Listings name owners through the same resolver, falling back to the ids.
+func TestLongnameNamesIDs(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_SIZE | SSH_FILEXFER_ATTR_UIDGID | SSH_FILEXFER_ATTR_PERMISSIONS | SSH_FILEXFER_ATTR_ACMODTIME,
+		Stat:  attrs{Size: 42, UID: 1000, GID: 7, Permissions: S_IFREG | 0644, MTime: uint32(now.Add(-time.Hour).Unix())},
+	}
+
+	expected := "-rw-r--r--   1 bob      7              42 Jun  1 11:00 file"
+	if longname := formatLongname("file", fAttrs, testIDs, now); longname != expected {
+		t.Fatalf("Expected %q, found %q", expected, longname)
+	}
+}
@@ -0,0 +1,80 @@
+package bsftp
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// linkAndTarget are the paths of an SSH_FXP_SYMLINK in the order OpenSSH,
+// and so nearly every client, sends them: the target first, the reverse of
+// the draft the packet's fields are named after.
+func (p *sshFXPSymlinkPacket) linkAndTarget() (string, string) {
+	return p.TargetPath, p.LinkPath
+}
+
+// handleSymlink creates a symbolic link. However the client expresses the
+// target, the link is written relative to the directory it is in, so that
+// it can never lead out of the root directory.
+func (s *Server) handleSymlink(p *sshFXPSymlinkPacket) sshFXPStatusPacket {
+	link, target := p.linkAndTarget()
+	link = s.realPath(link)
+	if path.IsAbs(target) {
+		target = cleanPath(target)
+	} else {
+		target = path.Join(path.Dir(link), target)
+	}
+
+	name := s.localPath(link)
+	relative, err := filepath.Rel(filepath.Dir(name), s.localPath(target))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	// a link is charged the length of its target, as its size is
+	if err := s.chargeQuota(uint64(len(relative)), 1); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if err := os.Symlink(relative, name); err != nil {
+		s.refundQuota(uint64(len(relative)), 1)
+		return statusFromError(p.ID, err)
+	}
+
+	return statusFromError(p.ID, nil)
+}
+
+// handleReadLink answers with the target of a symbolic link as the client
+// sees it. A link made outside the server pointing out of the root directory
+// is not followed, lest it reveal where the root directory lives.
+func (s *Server) handleReadLink(p *sshFXPReadLinkPacket) packetEncoder {
+	if s.fsys != nil {
+		target, err := fs.ReadLink(s.fsys, s.fsName(p.Path))
+		if err != nil {
+			return statusFromError(p.ID, err)
+		}
+
+		return namePacket(p.ID, target)
+	}
+
+	target, err := os.Readlink(s.localPath(p.Path))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	} else if !filepath.IsAbs(target) {
+		return namePacket(p.ID, filepath.ToSlash(target))
+	}
+
+	root, err := filepath.Abs(s.rootDirectory)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	relative, err := filepath.Rel(root, target)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return statusFromError(p.ID, permissionDeniedError)
+	}
+
+	return namePacket(p.ID, cleanPath(filepath.ToSlash(relative)))
+}
@@ -0,0 +1,100 @@
+package bsftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+
+	client := newTestClient(t, RootDirectory(root))
+	if err := client.Symlink("file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := client.ReadLink("/link"); err != nil {
+		t.Fatal(err)
+	} else if target != "file" {
+		t.Fatalf("Expected the link to lead to file, found %q", target)
+	}
+
+	if fi, err := client.Stat("/link"); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != int64(len("contents")) {
+		t.Fatalf("Expected the link to be followed, found %d bytes", fi.Size())
+	}
+}
+
+func TestSymlinkStaysWithinRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, RootDirectory(root))
+	for _, target := range []string{"/../../etc/passwd", "../../../etc/passwd"} {
+		if err := client.Symlink(target, "/dir/escape"); err != nil {
+			t.Fatal(err)
+		}
+
+		local, err := os.Readlink(filepath.Join(root, "dir", "escape"))
+		if err != nil {
+			t.Fatal(err)
+		} else if local != filepath.Join("..", "etc", "passwd") {
+			t.Fatalf("Expected the link confined to the root, found %q", local)
+		}
+
+		if err := client.Remove("/dir/escape"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	} else if err := os.Symlink(os.TempDir(), filepath.Join(root, "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := client.ReadLink("/inside"); err != nil {
+		t.Fatal(err)
+	} else if target != "/dir" {
+		t.Fatalf("Expected the link to lead to /dir, found %q", target)
+	}
+
+	if _, err := client.ReadLink("/outside"); err == nil {
+		t.Fatal("Reading a link leading out of the root succeeded")
+	}
+}
+
+func TestFSetStat(t *testing.T) {
+	root := t.TempDir()
+	quotas := NewQuotas()
+	quotas.SetLimit("bob", Quota{Bytes: 100, Files: 10})
+	client := newTestClient(t, RootDirectory(root), User("bob"), DiskQuotas(quotas))
+
+	f, err := client.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0640); err != nil {
+		t.Fatal(err)
+	} else if fi, err := os.Stat(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0640 {
+		t.Fatalf("Expected mode 0640, found %v", fi.Mode().Perm())
+	}
+
+	if err := f.Truncate(1 << 30); err == nil {
+		t.Fatal("Growing a file past the quota succeeded")
+	}
+
+	if err := f.Truncate(50); err != nil {
+		t.Fatal(err)
+	} else if usage, _ := quotas.Usage("bob"); usage.Bytes != 50 {
+		t.Fatalf("Expected 50 bytes charged, found %d", usage.Bytes)
+	}
+}
@@ -0,0 +1,82 @@
+package bsftp
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// longname renders a file the way `ls -l' would, as SSH_FXP_NAME replies to
+// SSH_FXP_READDIR are expected to carry for display by the client.
+func (s *Server) longname(name string, fAttrs fileAttributes) string {
+	return formatLongname(name, fAttrs, s.idResolver, time.Now())
+}
+
+func formatLongname(name string, fAttrs fileAttributes, resolver IDResolver, now time.Time) string {
+	owner, group := "?", "?"
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_UIDGID == SSH_FILEXFER_ATTR_UIDGID {
+		owner = resolveIDName(fAttrs.Stat.UID, resolver.LookupUser)
+		group = resolveIDName(fAttrs.Stat.GID, resolver.LookupGroup)
+	}
+
+	date := "            "
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_ACMODTIME == SSH_FILEXFER_ATTR_ACMODTIME {
+		mtime := time.Unix(int64(fAttrs.Stat.MTime), 0)
+
+		// like ls, only show the time of day for files modified in the past six months
+		if sixMonths := 182 * 24 * time.Hour; mtime.After(now.Add(-sixMonths)) && !mtime.After(now.Add(sixMonths)) {
+			date = mtime.Format("Jan _2 15:04")
+		} else {
+			date = mtime.Format("Jan _2  2006")
+		}
+	}
+
+	return fmt.Sprintf("%s %3d %-8s %-8s %8d %s %s", permissionString(fAttrs.Stat.Permissions), 1, owner, group, fAttrs.Stat.Size, date, name)
+}
+
+// resolveIDName falls back to the numeric id when it has no name.
+func resolveIDName(id uint32, lookup func(uint32) (string, error)) string {
+	if name, err := lookup(id); err == nil && name != "" {
+		return name
+	}
+
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// permissionString renders a posix mode like `drwxr-xr-x'.
+func permissionString(mode uint32) string {
+	b := []byte("?rwxrwxrwx")
+
+	switch mode & S_IFMT {
+		case S_IFREG: b[0] = '-'
+		case S_IFDIR: b[0] = 'd'
+		case S_IFLNK: b[0] = 'l'
+		case S_IFCHR: b[0] = 'c'
+		case S_IFBLK: b[0] = 'b'
+		case S_IFIFO: b[0] = 'p'
+		case S_IFSOCK: b[0] = 's'
+	}
+
+	for i := uint(0); i < 9; i++ {
+		if mode&(1<<(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+
+	setSpecialBit(b, 3, mode&S_ISUID != 0, 's')
+	setSpecialBit(b, 6, mode&S_ISGID != 0, 's')
+	setSpecialBit(b, 9, mode&S_ISVTX != 0, 't')
+	return string(b)
+}
+
+// setSpecialBit overlays a setuid, setgid or sticky bit on an execute bit,
+// using the upper case form when the execute bit itself is unset.
+func setSpecialBit(b []byte, i int, set bool, c byte) {
+	if !set {
+		return
+	} else if b[i] == '-' {
+		c -= 'a' - 'A'
+	}
+
+	b[i] = c
+}
@@ -0,0 +1,197 @@
+package bsftp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements from every session of a server. It is called
+// concurrently by the server's workers.
+type Metrics interface {
+	// ObserveRequest records a request of the given SSH_FXP_* type once it
+	// has been answered, with the size of the request and reply packets.
+	ObserveRequest(packetType byte, statusCode uint32, latency time.Duration, bytesIn, bytesOut int)
+	AddOpenHandles(delta int)
+	AddActiveSessions(delta int)
+	// AddQueuedRequests tracks requests waiting for one of the
+	// SftpServerWorkerCount workers of a session.
+	AddQueuedRequests(delta int)
+}
+
+// LatencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram buckets.
+var LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	Type   byte
+	Status uint32
+}
+
+type histogram struct {
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// PrometheusMetrics keeps measurements in memory and exposes them in the
+// Prometheus text format. It is an http.Handler, ready to be scraped.
+type PrometheusMetrics struct {
+	lock           sync.Mutex
+	requests       map[requestKey]uint64
+	latencies      map[byte]*histogram
+	bytesIn        map[byte]uint64
+	bytesOut       map[byte]uint64
+	openHandles    int64
+	activeSessions int64
+	queuedRequests int64
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		requests:  make(map[requestKey]uint64),
+		latencies: make(map[byte]*histogram),
+		bytesIn:   make(map[byte]uint64),
+		bytesOut:  make(map[byte]uint64),
+	}
+}
+
+func (m *PrometheusMetrics) ObserveRequest(packetType byte, statusCode uint32, latency time.Duration, bytesIn, bytesOut int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.requests[requestKey{packetType, statusCode}]++
+	m.bytesIn[packetType] += uint64(bytesIn)
+	m.bytesOut[packetType] += uint64(bytesOut)
+
+	h, ok := m.latencies[packetType]
+	if !ok {
+		h = &histogram{Counts: make([]uint64, len(LatencyBuckets))}
+		m.latencies[packetType] = h
+	}
+
+	seconds := latency.Seconds()
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.Counts[i]++
+		}
+	}
+
+	h.Count++
+	h.Sum += seconds
+}
+
+func (m *PrometheusMetrics) AddOpenHandles(delta int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.openHandles += int64(delta)
+}
+
+func (m *PrometheusMetrics) AddActiveSessions(delta int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.activeSessions += int64(delta)
+}
+
+func (m *PrometheusMetrics) AddQueuedRequests(delta int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.queuedRequests += int64(delta)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ew := &errWriter{w: w}
+
+	ew.printf("# HELP bsftp_requests_total Requests answered, by packet type and status.\n")
+	ew.printf("# TYPE bsftp_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Type < keys[j].Type || keys[i].Type == keys[j].Type && keys[i].Status < keys[j].Status
+	})
+
+	for _, key := range keys {
+		ew.printf("bsftp_requests_total{type=%q,status=%q} %d\n", packetTypeName(key.Type), statusCodeName(key.Status), m.requests[key])
+	}
+
+	ew.printf("# HELP bsftp_request_duration_seconds Time taken to answer requests, by packet type.\n")
+	ew.printf("# TYPE bsftp_request_duration_seconds histogram\n")
+	for _, tahyp := range sortedTypes(m.latencies) {
+		h, name := m.latencies[tahyp], packetTypeName(tahyp)
+		for i, bound := range LatencyBuckets {
+			ew.printf("bsftp_request_duration_seconds_bucket{type=%q,le=%q} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.Counts[i])
+		}
+
+		ew.printf("bsftp_request_duration_seconds_bucket{type=%q,le=\"+Inf\"} %d\n", name, h.Count)
+		ew.printf("bsftp_request_duration_seconds_sum{type=%q} %g\n", name, h.Sum)
+		ew.printf("bsftp_request_duration_seconds_count{type=%q} %d\n", name, h.Count)
+	}
+
+	ew.printf("# HELP bsftp_received_bytes_total Bytes received in requests, by packet type.\n")
+	ew.printf("# TYPE bsftp_received_bytes_total counter\n")
+	for _, tahyp := range sortedTypes(m.bytesIn) {
+		ew.printf("bsftp_received_bytes_total{type=%q} %d\n", packetTypeName(tahyp), m.bytesIn[tahyp])
+	}
+
+	ew.printf("# HELP bsftp_sent_bytes_total Bytes sent in replies, by request packet type.\n")
+	ew.printf("# TYPE bsftp_sent_bytes_total counter\n")
+	for _, tahyp := range sortedTypes(m.bytesOut) {
+		ew.printf("bsftp_sent_bytes_total{type=%q} %d\n", packetTypeName(tahyp), m.bytesOut[tahyp])
+	}
+
+	ew.printf("# HELP bsftp_open_handles Files and directories held open by clients.\n")
+	ew.printf("# TYPE bsftp_open_handles gauge\n")
+	ew.printf("bsftp_open_handles %d\n", m.openHandles)
+	ew.printf("# HELP bsftp_active_sessions Sessions being served.\n")
+	ew.printf("# TYPE bsftp_active_sessions gauge\n")
+	ew.printf("bsftp_active_sessions %d\n", m.activeSessions)
+	ew.printf("# HELP bsftp_queued_requests Requests waiting for a worker.\n")
+	ew.printf("# TYPE bsftp_queued_requests gauge\n")
+	ew.printf("bsftp_queued_requests %d\n", m.queuedRequests)
+
+	return ew.n, ew.err
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+func sortedTypes[V any](m map[byte]V) []byte {
+	types := make([]byte, 0, len(m))
+	for tahyp := range m {
+		types = append(types, tahyp)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// errWriter remembers the first error encountered so a sequence of writes
+// need only be checked once.
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+	ew.err = err
+}
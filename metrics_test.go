@@ -0,0 +1,98 @@
+package bsftp
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetricsCountsRequests(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	server, rwc := startServer(t, RootDirectory(root), Instrument(metrics))
+	c := handshake(t, rwc)
+
+	c.roundTrip(&sshFXPStatPacket{ID: 1, Path: "/file"})
+	expectStatus(t, SSH_FX_NO_SUCH_FILE, c.status(&sshFXPStatPacket{ID: 2, Path: "/missing"}))
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 3, Filename: "/file", PFlags: SSH_FXF_READ})
+	metrics.lock.Lock()
+	openHandles, activeSessions := metrics.openHandles, metrics.activeSessions
+	metrics.lock.Unlock()
+
+	if openHandles != 1 || activeSessions != 1 {
+		t.Fatalf("Expected 1 open handle in 1 session, found %d in %d", openHandles, activeSessions)
+	}
+
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPClosePacket{ID: 4, Handle: handle}))
+
+	// requests are observed once answered, so wait for the session to end
+	rwc.Close()
+	select {
+		case <-server.done:
+		case <-time.After(5 * time.Second): t.Fatal("Server did not stop serving")
+	}
+
+	out := &bytes.Buffer{}
+	if _, err := metrics.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`bsftp_requests_total{type="SSH_FXP_STAT",status="SSH_FX_OK"} 1`,
+		`bsftp_requests_total{type="SSH_FXP_STAT",status="SSH_FX_NO_SUCH_FILE"} 1`,
+		`bsftp_requests_total{type="SSH_FXP_OPEN",status="SSH_FX_OK"} 1`,
+		`bsftp_requests_total{type="SSH_FXP_CLOSE",status="SSH_FX_OK"} 1`,
+		`bsftp_request_duration_seconds_count{type="SSH_FXP_STAT"} 2`,
+		`bsftp_request_duration_seconds_bucket{type="SSH_FXP_STAT",le="+Inf"} 2`,
+		`bsftp_received_bytes_total{type="SSH_FXP_STAT"} 39`,
+		`bsftp_open_handles 0`,
+		`bsftp_active_sessions 0`,
+		`bsftp_queued_requests 0`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestPrometheusMetricsLatencyBuckets(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	metrics.ObserveRequest(SSH_FXP_READ, SSH_FX_OK, 3*time.Millisecond, 10, 100)
+	metrics.ObserveRequest(SSH_FXP_READ, SSH_FX_EOF, 2*time.Second, 10, 20)
+
+	out := &bytes.Buffer{}
+	if _, err := metrics.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`bsftp_request_duration_seconds_bucket{type="SSH_FXP_READ",le="0.0025"} 0`,
+		`bsftp_request_duration_seconds_bucket{type="SSH_FXP_READ",le="0.005"} 1`,
+		`bsftp_request_duration_seconds_bucket{type="SSH_FXP_READ",le="1"} 1`,
+		`bsftp_request_duration_seconds_bucket{type="SSH_FXP_READ",le="2.5"} 2`,
+		`bsftp_request_duration_seconds_sum{type="SSH_FXP_READ"} 2.003`,
+		`bsftp_received_bytes_total{type="SSH_FXP_READ"} 20`,
+		`bsftp_sent_bytes_total{type="SSH_FXP_READ"} 120`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestPrometheusMetricsServeHTTP(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	metrics.AddOpenHandles(2)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("Unexpected content type %q", contentType)
+	} else if !strings.Contains(recorder.Body.String(), "bsftp_open_handles 2\n") {
+		t.Fatalf("Expected 2 open handles in:\n%s", recorder.Body)
+	}
+}
@@ -0,0 +1,394 @@
+package bsftp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConcurrentFiles sets how many files a tree transfer copies at once.
+func ConcurrentFiles(n int) TransferOption {
+	return func(t *transfer) error {
+		if n < 1 {
+			return invalidConcurrencyError
+		}
+
+		t.files = n
+		return nil
+	}
+}
+
+// DeleteExtraneous makes a mirror remove whatever the destination holds that
+// the source does not.
+func DeleteExtraneous() TransferOption {
+	return func(t *transfer) error {
+		t.deleteExtraneous = true
+		return nil
+	}
+}
+
+// TransferError reports a file of a tree transfer that failed. The transfer
+// carries on with the rest.
+type TransferError struct {
+	Path string // slash-separated, relative to the root of the tree
+	Err  error
+}
+
+func (e *TransferError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// TransferErrors lists every file of a tree transfer that failed.
+type TransferErrors []*TransferError
+
+func (e TransferErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	return fmt.Sprintf("%d files failed to transfer, the first %s", len(e), e[0])
+}
+
+// transferErrors collects the failures of the files of a tree transfer as
+// they are copied concurrently.
+type transferErrors struct {
+	sync.Mutex
+	errs TransferErrors
+}
+
+func (e *transferErrors) report(rel string, err error) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.errs = append(e.errs, &TransferError{Path: rel, Err: err})
+}
+
+func (e *transferErrors) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+
+	sort.Slice(e.errs, func(i, j int) bool { return e.errs[i].Path < e.errs[j].Path })
+	return e.errs
+}
+
+// treeSide is one end of a tree transfer, on either the local or the remote
+// filesystem.
+type treeSide struct {
+	root   string
+	join   func(root, rel string) string
+	list   func(root string, report func(rel string, err error)) (map[string]os.FileInfo, error)
+	mkdir  func(name string) error
+	stat   func(name string) (os.FileInfo, error)
+	remove func(name string, fi os.FileInfo) error
+}
+
+func (c *Client) remoteSide(root string) treeSide {
+	return treeSide{
+		root:  root,
+		join:  func(root, rel string) string { return path.Join(root, rel) },
+		list:  c.remoteTree,
+		mkdir: c.Mkdir,
+		stat:  c.Stat,
+		remove: func(name string, fi os.FileInfo) error {
+			if fi.IsDir() {
+				return c.RemoveDirectory(name)
+			}
+
+			return c.Remove(name)
+		},
+	}
+}
+
+func localSide(root string) treeSide {
+	return treeSide{
+		root:  root,
+		join:  func(root, rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) },
+		list:  localTree,
+		mkdir: func(name string) error { return os.Mkdir(name, 0755) },
+		stat:  os.Stat,
+		remove: func(name string, fi os.FileInfo) error { return os.Remove(name) },
+	}
+}
+
+// remoteTree describes every file below a remote root by its path relative to
+// the root, which is itself ".".
+func (c *Client) remoteTree(root string, report func(rel string, err error)) (map[string]os.FileInfo, error) {
+	tree := make(map[string]os.FileInfo)
+	err := c.Walk(root, func(name string, d fs.DirEntry, err error) error {
+		rel := relativePath(root, name)
+		if err != nil {
+			if rel == "." {
+				return err
+			}
+
+			report(rel, err)
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			report(rel, err)
+			return nil
+		}
+
+		tree[rel] = fi
+		return nil
+	})
+
+	return tree, err
+}
+
+// localTree describes every file below a local root by its slash-separated
+// path relative to the root, which is itself ".".
+func localTree(root string, report func(rel string, err error)) (map[string]os.FileInfo, error) {
+	tree := make(map[string]os.FileInfo)
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(root, name)
+		if relErr != nil {
+			return relErr
+		}
+
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			if rel == "." {
+				return err
+			}
+
+			report(rel, err)
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			report(rel, err)
+			return nil
+		}
+
+		tree[rel] = fi
+		return nil
+	})
+
+	return tree, err
+}
+
+func relativePath(root, name string) string {
+	root, name = path.Clean(root), path.Clean(name)
+	switch {
+		case name == root: return "."
+		case root == ".": return name
+		case root == "/": return name[1:]
+	}
+
+	return name[len(root)+1:]
+}
+
+// Upload copies a local directory tree into a remote directory, creating it
+// if need be.
+func (c *Client) Upload(local, remote string, options ...TransferOption) error {
+	return c.transferTree(localSide(local), c.remoteSide(remote), c.putFile, false, options)
+}
+
+// Download copies a remote directory tree into a local directory, creating it
+// if need be.
+func (c *Client) Download(remote, local string, options ...TransferOption) error {
+	return c.transferTree(c.remoteSide(remote), localSide(local), c.getFile, false, options)
+}
+
+// Mirror brings a remote directory tree up to date with a local one, copying
+// only the files whose size or modification time differ.
+func (c *Client) Mirror(local, remote string, options ...TransferOption) error {
+	return c.transferTree(localSide(local), c.remoteSide(remote), c.putFile, true, options)
+}
+
+// MirrorDownload brings a local directory tree up to date with a remote one,
+// copying only the files whose size or modification time differ.
+func (c *Client) MirrorDownload(remote, local string, options ...TransferOption) error {
+	return c.transferTree(c.remoteSide(remote), localSide(local), c.getFile, true, options)
+}
+
+// transferTree copies the regular files of a tree along with its directories,
+// several files at a time. Files that fail are reported in TransferErrors
+// once the rest are done. Progress is reported for each file on its own, and
+// may be reported for several at once.
+func (c *Client) transferTree(src, dst treeSide, copyFile func(src, dst string, fi os.FileInfo, t *transfer) error, mirror bool, options []TransferOption) error {
+	t, err := newTransfer(options)
+	if err != nil {
+		return err
+	}
+
+	// what could not be listed of the source may still exist there
+	errs := &transferErrors{}
+	unlisted := make(map[string]bool)
+	srcTree, err := src.list(src.root, func(rel string, err error) {
+		unlisted[rel] = true
+		errs.report(rel, err)
+	})
+	if err != nil {
+		return err
+	} else if !srcTree["."].IsDir() {
+		return &os.PathError{Op: "walk", Path: src.root, Err: notDirectoryError}
+	}
+
+	dstTree := make(map[string]os.FileInfo)
+	if mirror {
+		if dstTree, err = dst.list(dst.root, errs.report); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// parents sort before their children, so are created first
+	var files []string
+	for _, rel := range sortedPaths(srcTree) {
+		fi, existing := srcTree[rel], dstTree[rel]
+		switch {
+			case fi.IsDir():
+				if existing == nil || !existing.IsDir() {
+					if err := makeDirectory(dst, dst.join(dst.root, rel)); err != nil {
+						errs.report(rel, err)
+					}
+				}
+			case fi.Mode().IsRegular():
+				if existing == nil || !unchanged(fi, existing) {
+					files = append(files, rel)
+				}
+		}
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < t.files; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				if err := copyFile(src.join(src.root, rel), dst.join(dst.root, rel), srcTree[rel], t); err != nil {
+					errs.report(rel, err)
+				}
+			}
+		}()
+	}
+
+	for _, rel := range files {
+		jobs <- rel
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if mirror && t.deleteExtraneous {
+		// children sort after their parents, so are removed first
+		extraneous := sortedPaths(dstTree)
+		for i := len(extraneous) - 1; i >= 0; i-- {
+			rel := extraneous[i]
+			if _, ok := srcTree[rel]; !ok && !withinUnlisted(rel, unlisted) {
+				if err := dst.remove(dst.join(dst.root, rel), dstTree[rel]); err != nil {
+					errs.report(rel, err)
+				}
+			}
+		}
+	}
+
+	return errs.err()
+}
+
+// withinUnlisted reports whether a path, or any directory it is in, could
+// not be listed.
+func withinUnlisted(rel string, unlisted map[string]bool) bool {
+	for ; rel != "."; rel = path.Dir(rel) {
+		if unlisted[rel] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// makeDirectory creates a directory unless it already exists.
+func makeDirectory(side treeSide, name string) error {
+	err := side.mkdir(name)
+	if err != nil {
+		if fi, statErr := side.stat(name); statErr == nil && fi.IsDir() {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// unchanged reports whether a file seems the same as its copy, going by their
+// size and modification time to the second.
+func unchanged(fi, existing os.FileInfo) bool {
+	return existing.Mode().IsRegular() && fi.Size() == existing.Size() && fi.ModTime().Unix() == existing.ModTime().Unix()
+}
+
+func sortedPaths(tree map[string]os.FileInfo) []string {
+	paths := make([]string, 0, len(tree))
+	for rel := range tree {
+		paths = append(paths, rel)
+	}
+
+	// the root comes first even though some names sort before "."
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[j] != "." && (paths[i] == "." || paths[i] < paths[j])
+	})
+
+	return paths
+}
+
+// putFile uploads a single file, keeping its modification time.
+func (c *Client) putFile(local, remote string, fi os.FileInfo, t *transfer) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := c.Create(remote)
+	if err != nil {
+		return err
+	}
+
+	if err := t.copy(dst, src, 0, fi.Size()); err != nil {
+		dst.Close()
+		return err
+	} else if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return c.Chtimes(remote, time.Now(), fi.ModTime())
+}
+
+// getFile downloads a single file, keeping its modification time.
+func (c *Client) getFile(remote, local string, fi os.FileInfo, t *transfer) error {
+	src, err := c.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+
+	if err := t.copy(dst, src, 0, fi.Size()); err != nil {
+		dst.Close()
+		return err
+	} else if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(local, time.Now(), fi.ModTime())
+}
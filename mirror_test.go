@@ -0,0 +1,109 @@
+package bsftp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// denyListing is a policy refusing to list a single directory.
+type denyListing string
+
+func (d denyListing) Allow(username string, op Operation, path string) bool {
+	return op != OP_LIST || path != string(d)
+}
+
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	tree := make(map[string]string)
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		b, err := os.ReadFile(name)
+		rel, _ := filepath.Rel(root, name)
+		tree[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestUploadAndDownloadTree(t *testing.T) {
+	files := map[string]string{"a": "a", "dir/b": "b", "dir/sub/c": "c"}
+	local := newTestRoot(t, files)
+	remote := t.TempDir()
+	client := newTestClient(t, RootDirectory(remote))
+
+	if err := client.Upload(local, "/tree"); err != nil {
+		t.Fatal(err)
+	} else if tree := readTree(t, filepath.Join(remote, "tree")); !reflect.DeepEqual(tree, files) {
+		t.Fatalf("Expected %v uploaded, found %v", files, tree)
+	}
+
+	var walked []string
+	err := client.Walk("/tree", func(name string, d fs.DirEntry, err error) error {
+		walked = append(walked, name)
+		return err
+	})
+
+	expected := []string{"/tree", "/tree/a", "/tree/dir", "/tree/dir/b", "/tree/dir/sub", "/tree/dir/sub/c"}
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("Expected to walk %q, walked %q", expected, walked)
+	}
+
+	downloaded := t.TempDir()
+	if err := client.Download("/tree", downloaded); err != nil {
+		t.Fatal(err)
+	} else if tree := readTree(t, downloaded); !reflect.DeepEqual(tree, files) {
+		t.Fatalf("Expected %v downloaded, found %v", files, tree)
+	}
+}
+
+func TestMirrorDeletesExtraneous(t *testing.T) {
+	local := newTestRoot(t, map[string]string{"kept": "newer", "dir/file": "file"})
+	remote := newTestRoot(t, map[string]string{"kept": "old", "gone": "gone", "dir/gone": "gone", "old/file": "file"})
+	client := newTestClient(t, RootDirectory(remote))
+
+	if err := client.Mirror(local, "/", DeleteExtraneous()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"kept": "newer", "dir/file": "file"}
+	if tree := readTree(t, remote); !reflect.DeepEqual(tree, expected) {
+		t.Fatalf("Expected %v mirrored, found %v", expected, tree)
+	} else if _, err := os.Stat(filepath.Join(remote, "old")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the extraneous directory removed, found %v", err)
+	}
+}
+
+// Files of the destination under a source directory that could not be
+// listed may well exist in the source, so are never deleted.
+func TestMirrorKeepsWhatCouldNotBeListed(t *testing.T) {
+	remote := newTestRoot(t, map[string]string{"file": "file", "secret/file": "secret"})
+	local := newTestRoot(t, map[string]string{"gone": "gone", "secret/file": "secret", "secret/other": "other"})
+	client := newTestClient(t, RootDirectory(remote), AccessPolicy(denyListing("/secret")))
+
+	err := client.MirrorDownload("/", local, DeleteExtraneous())
+	var errs TransferErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "secret" {
+		t.Fatalf("Expected the secret directory reported, found %v", err)
+	}
+
+	expected := map[string]string{"file": "file", "secret/file": "secret", "secret/other": "other"}
+	if tree := readTree(t, local); !reflect.DeepEqual(tree, expected) {
+		t.Fatalf("Expected %v left, found %v", expected, tree)
+	}
+}
@@ -0,0 +1,74 @@
+package bsftp
+
+import "encoding"
+
+type accessMode int
+
+const (
+	readWriteMode accessMode = iota
+	readOnlyMode
+	uploadOnlyMode
+)
+
+// ReadOnly refuses every request that would modify the filesystem, making for
+// a distribution point.
+func ReadOnly() ServerOption {
+	return func(s *Server) error {
+		return s.setAccessMode(readOnlyMode)
+	}
+}
+
+// UploadOnly refuses every request that would list or download files, making
+// for a drop box.
+func UploadOnly() ServerOption {
+	return func(s *Server) error {
+		return s.setAccessMode(uploadOnlyMode)
+	}
+}
+
+func (s *Server) setAccessMode(mode accessMode) error {
+	if s.accessMode != readWriteMode && s.accessMode != mode {
+		return conflictingModesError
+	}
+
+	s.accessMode = mode
+	return nil
+}
+
+// checkAccessMode refuses requests the server's mode does not permit.
+func (s *Server) checkAccessMode(p encoding.BinaryUnmarshaler) error {
+	switch s.accessMode {
+		case readOnlyMode:
+			if modifies(p) {
+				return readOnlyError
+			}
+		case uploadOnlyMode:
+			if downloads(p) {
+				return uploadOnlyError
+			}
+	}
+
+	return nil
+}
+
+func modifies(p encoding.BinaryUnmarshaler) bool {
+	switch p := p.(type) {
+		case *sshFXPOpenPacket: return opensForWriting(p.PFlags)
+		case *sshFXPExtendedPacket: return p.ExtendedRequest == LSETSTAT_EXTENSION
+		case *sshFXPWritePacket, *sshFXPSetStatPacket, *sshFXPFSetStatPacket, *sshFXPRemovePacket,
+			*sshFXPMkDirPacket, *sshFXPRmDirPacket, *sshFXPRenamePacket, *sshFXPSymlinkPacket:
+			return true
+	}
+
+	return false
+}
+
+func downloads(p encoding.BinaryUnmarshaler) bool {
+	switch p := p.(type) {
+		case *sshFXPOpenPacket: return opensForReading(p.PFlags)
+		case *sshFXPExtendedPacket: return p.ExtendedRequest == CHECK_FILE_HANDLE_EXTENSION
+		case *sshFXPOpenDirPacket, *sshFXPReadDirPacket, *sshFXPReadPacket: return true
+	}
+
+	return false
+}
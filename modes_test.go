@@ -0,0 +1,98 @@
+package bsftp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOnlyRefusesModifications(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	c := newTestConn(t, RootDirectory(root), ReadOnly())
+
+	fAttrs := fileAttributes{Flags: SSH_FILEXFER_ATTR_PERMISSIONS, Stat: attrs{Permissions: 0777}}
+	for _, p := range []packetEncoder{
+		&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE},
+		&sshFXPOpenPacket{ID: 2, Filename: "/new", PFlags: SSH_FXF_READ | SSH_FXF_CREAT},
+		&sshFXPSetStatPacket{ID: 3, Path: "/file", Attrs: fAttrs},
+		lsetStatRequest(4, "/file", fAttrs),
+		&sshFXPRemovePacket{ID: 5, Filename: "/file"},
+		&sshFXPRenamePacket{ID: 6, OldPath: "/file", NewPath: "/renamed"},
+		&sshFXPMkDirPacket{ID: 7, Path: "/dir"},
+		&sshFXPSymlinkPacket{ID: 8, LinkPath: "file", TargetPath: "/link"},
+	} {
+		expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(p))
+	}
+
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Fatalf("Expected the root left as it was, found %d files", len(entries))
+	} else if fi, _ := os.Stat(filepath.Join(root, "file")); fi.Mode().Perm() != 0600 {
+		t.Fatalf("File changed to mode %v", fi.Mode().Perm())
+	}
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 100, Filename: "/file", PFlags: SSH_FXF_READ})
+	if tahyp, _ := c.roundTrip(&sshFXPReadPacket{ID: 101, Handle: handle, Len: 100}); tahyp != SSH_FXP_DATA {
+		t.Fatalf("Expected SSH_FXP_DATA, received %s", packetTypeName(tahyp))
+	}
+}
+
+func TestUploadOnlyRefusesDownloads(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"dropped": "dropped"})
+	c := newTestConn(t, RootDirectory(root), UploadOnly())
+
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPOpenPacket{ID: 1, Filename: "/dropped", PFlags: SSH_FXF_READ}))
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPOpenPacket{ID: 2, Filename: "/dropped", PFlags: SSH_FXF_READ | SSH_FXF_WRITE}))
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPOpenDirPacket{ID: 3, Path: "/"}))
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 4, Filename: "/upload", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPWritePacket{ID: 5, Handle: handle, Data: []byte("upload")}))
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPReadPacket{ID: 6, Handle: handle, Len: 100}))
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(checkFileRequest(7, handle, 0, 0)))
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPClosePacket{ID: 8, Handle: handle}))
+
+	if b, _ := os.ReadFile(filepath.Join(root, "upload")); string(b) != "upload" {
+		t.Fatalf("Expected the upload stored, found %q", b)
+	}
+}
+
+// A handle opened for writing only cannot be read back as hashes either.
+func TestCheckFileNeedsReadableHandle(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	c := newTestConn(t, RootDirectory(root))
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE})
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(checkFileRequest(2, handle, 0, 0)))
+
+	handle = c.handle(&sshFXPOpenPacket{ID: 3, Filename: "/file", PFlags: SSH_FXF_READ})
+	if tahyp, _ := c.roundTrip(checkFileRequest(4, handle, 0, 0)); tahyp != SSH_FXP_EXTENDED_REPLY {
+		t.Fatalf("Expected SSH_FXP_EXTENDED_REPLY, received %s", packetTypeName(tahyp))
+	}
+}
+
+// Resuming a verified upload to a server that will not let it be read back
+// starts over.
+func TestPutResumeVerifiedUploadOnly(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"upload": "stale"})
+	local := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(local, []byte("fresh contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, RootDirectory(root), UploadOnly())
+	if err := client.PutResume(local, "/upload", Verify()); err != nil {
+		t.Fatal(err)
+	} else if b, _ := os.ReadFile(filepath.Join(root, "upload")); string(b) != "fresh contents" {
+		t.Fatalf("Expected the upload started over, found %q", b)
+	}
+}
+
+func TestConflictingModes(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := NewServer(a, ReadOnly(), UploadOnly()); err != conflictingModesError {
+		t.Fatalf("Expected %v, received %v", conflictingModesError, err)
+	}
+}
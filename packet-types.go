@@ -1,6 +1,10 @@
 package bsftp
 
-import "encoding"
+import (
+	"encoding"
+	"fmt"
+	"io"
+)
 
 const (
 	SSH_FXP_INIT           = 1
@@ -31,6 +35,44 @@ const (
     SSH_FXP_EXTENDED       = 200
 	SSH_FXP_EXTENDED_REPLY = 201
 )
+var packetTypeNames = map[byte]string{
+	SSH_FXP_INIT: "SSH_FXP_INIT",
+	SSH_FXP_VERSION: "SSH_FXP_VERSION",
+	SSH_FXP_OPEN: "SSH_FXP_OPEN",
+	SSH_FXP_CLOSE: "SSH_FXP_CLOSE",
+	SSH_FXP_READ: "SSH_FXP_READ",
+	SSH_FXP_WRITE: "SSH_FXP_WRITE",
+	SSH_FXP_LSTAT: "SSH_FXP_LSTAT",
+	SSH_FXP_FSTAT: "SSH_FXP_FSTAT",
+	SSH_FXP_SETSTAT: "SSH_FXP_SETSTAT",
+	SSH_FXP_FSETSTAT: "SSH_FXP_FSETSTAT",
+	SSH_FXP_OPENDIR: "SSH_FXP_OPENDIR",
+	SSH_FXP_READDIR: "SSH_FXP_READDIR",
+	SSH_FXP_REMOVE: "SSH_FXP_REMOVE",
+	SSH_FXP_MKDIR: "SSH_FXP_MKDIR",
+	SSH_FXP_RMDIR: "SSH_FXP_RMDIR",
+	SSH_FXP_REALPATH: "SSH_FXP_REALPATH",
+	SSH_FXP_STAT: "SSH_FXP_STAT",
+	SSH_FXP_RENAME: "SSH_FXP_RENAME",
+	SSH_FXP_READLINK: "SSH_FXP_READLINK",
+	SSH_FXP_SYMLINK: "SSH_FXP_SYMLINK",
+	SSH_FXP_STATUS: "SSH_FXP_STATUS",
+	SSH_FXP_HANDLE: "SSH_FXP_HANDLE",
+	SSH_FXP_DATA: "SSH_FXP_DATA",
+	SSH_FXP_NAME: "SSH_FXP_NAME",
+	SSH_FXP_ATTRS: "SSH_FXP_ATTRS",
+	SSH_FXP_EXTENDED: "SSH_FXP_EXTENDED",
+	SSH_FXP_EXTENDED_REPLY: "SSH_FXP_EXTENDED_REPLY",
+}
+
+func packetTypeName(tahyp byte) string {
+	if name, ok := packetTypeNames[tahyp]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SSH_FXP_%d", tahyp)
+}
+
 type sshFXPPacket struct {
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
@@ -50,14 +92,19 @@ type sshFXPInitPacket struct {
 }
 
 func (p sshFXPInitPacket) MarshalBinary() ([]byte, error) {
-	b:= makePacketHeader(SSH_FXP_INIT, p.Version, p.Extensions)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPInitPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_INIT)
 	b = marshalUint32(b, p.Version)
-	return marshalExtensions(b, p.Extensions), nil
+	return endPacket(marshalExtensions(b, p.Extensions), start)
 }
 
 func (p *sshFXPInitPacket) UnmarshalBinary(b []byte) error {
 	var err error
-	p.Version, b, err = unmarshalUint32Safe(b)	
+	if p.Version, b, err = unmarshalUint32Safe(b); err != nil { return err }
+	p.Extensions, b, err = unmarshalExtensionsSafe(b)
 	return err
 }
 
@@ -69,14 +116,19 @@ type sshFXPVersionPacket struct {
 }
 
 func (p sshFXPVersionPacket) MarshalBinary() ([]byte, error) {
-	b:= makePacketHeader(SSH_FXP_VERSION, p.Version, p.Extensions)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPVersionPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_VERSION)
 	b = marshalUint32(b, p.Version)
-	return marshalExtensions(b, p.Extensions), nil
+	return endPacket(marshalExtensions(b, p.Extensions), start)
 }
 
 func (p *sshFXPVersionPacket) UnmarshalBinary(b []byte) error {
 	var err error
-	p.Version, b, err = unmarshalUint32Safe(b)	
+	if p.Version, b, err = unmarshalUint32Safe(b); err != nil { return err }
+	p.Extensions, b, err = unmarshalExtensionsSafe(b)
 	return err
 }
 
@@ -98,11 +150,15 @@ type sshFXPOpenPacket struct {
 }
 
 func (p sshFXPOpenPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_OPEN, p.ID, p.Filename, p.PFlags, p.Attrs)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPOpenPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_OPEN)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Filename)
 	b = marshalUint32(b, p.PFlags)
-	return marshalFileAttributes(b, p.Attrs), nil
+	return endPacket(marshalFileAttributes(b, p.Attrs), start)
 }
 
 func (p *sshFXPOpenPacket) UnmarshalBinary(b []byte) error {
@@ -122,9 +178,13 @@ type sshFXPHandlePacket struct {
 }
 
 func (p sshFXPHandlePacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_HANDLE, p.ID, p.Handle)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPHandlePacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_HANDLE)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Handle), nil
+	return endPacket(marshalString(b, p.Handle), start)
 }
 
 func (p *sshFXPHandlePacket) UnmarshalBinary(b []byte) error {
@@ -135,6 +195,40 @@ func (p *sshFXPHandlePacket) UnmarshalBinary(b []byte) error {
 }
 
 
+const (
+	SSH_FX_OK                = 0
+	SSH_FX_EOF               = 1
+	SSH_FX_NO_SUCH_FILE      = 2
+	SSH_FX_PERMISSION_DENIED = 3
+	SSH_FX_FAILURE           = 4
+	SSH_FX_BAD_MESSAGE       = 5
+	SSH_FX_NO_CONNECTION     = 6
+	SSH_FX_CONNECTION_LOST   = 7
+	SSH_FX_OP_UNSUPPORTED    = 8
+)
+// statusLanguageTag is the language of the server's error messages.
+const statusLanguageTag = "en-us"
+
+var statusCodeNames = map[uint32]string{
+	SSH_FX_OK: "SSH_FX_OK",
+	SSH_FX_EOF: "SSH_FX_EOF",
+	SSH_FX_NO_SUCH_FILE: "SSH_FX_NO_SUCH_FILE",
+	SSH_FX_PERMISSION_DENIED: "SSH_FX_PERMISSION_DENIED",
+	SSH_FX_FAILURE: "SSH_FX_FAILURE",
+	SSH_FX_BAD_MESSAGE: "SSH_FX_BAD_MESSAGE",
+	SSH_FX_NO_CONNECTION: "SSH_FX_NO_CONNECTION",
+	SSH_FX_CONNECTION_LOST: "SSH_FX_CONNECTION_LOST",
+	SSH_FX_OP_UNSUPPORTED: "SSH_FX_OP_UNSUPPORTED",
+}
+
+func statusCodeName(code uint32) string {
+	if name, ok := statusCodeNames[code]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SSH_FX_%d", code)
+}
+
 type sshFXPStatusPacket struct {
 	sshFXPPacket
 	ID           uint32
@@ -144,11 +238,15 @@ type sshFXPStatusPacket struct {
 }
 
 func (p sshFXPStatusPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_STATUS, p.ID, p.StatusCode, p.ErrorMessage, "en-us")
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPStatusPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_STATUS)
 	b = marshalUint32(b, p.ID)
 	b = marshalUint32(b, p.StatusCode)
 	b = marshalString(b, p.ErrorMessage)
-	return marshalString(b, "en-us"), nil
+	return endPacket(marshalString(b, p.LanguageTag), start)
 }
 
 func (p *sshFXPStatusPacket) UnmarshalBinary(b []byte) error {
@@ -168,9 +266,13 @@ type sshFXPClosePacket struct {
 }
 
 func (p sshFXPClosePacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_CLOSE, p.ID, p.Handle)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPClosePacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_CLOSE)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Handle), nil
+	return endPacket(marshalString(b, p.Handle), start)
 }
 
 func (p *sshFXPClosePacket) UnmarshalBinary(b []byte) error {
@@ -190,11 +292,15 @@ type sshFXPReadPacket struct {
 }
 
 func (p sshFXPReadPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_READ, p.ID, p.Handle)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPReadPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_READ)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Handle)
 	b = marshalUint64(b, p.Offset)
-	return marshalUint32(b, p.Len), nil
+	return endPacket(marshalUint32(b, p.Len), start)
 }
 
 func (p *sshFXPReadPacket) UnmarshalBinary(b []byte) error {
@@ -210,37 +316,76 @@ func (p *sshFXPReadPacket) UnmarshalBinary(b []byte) error {
 type sshFXPDataPacket struct {
 	sshFXPPacket
 	ID   uint32
-	Data string
+	Data []byte
 }
 
+// MarshalBinary encodes the packet straight into a buffer of its exact size,
+// sparing the data the copy out of a pooled buffer marshalPacket makes.
 func (p sshFXPDataPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_DATA, p.ID, p.Data)
+	return p.appendPacket(make([]byte, 0, dataPacketHeaderSize+len(p.Data))), nil
+}
+
+func (p sshFXPDataPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_DATA)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Data), nil
+	return endPacket(marshalBytes(b, p.Data), start)
 }
 
 func (p *sshFXPDataPacket) UnmarshalBinary(b []byte) error {
 	var err error
 	if p.ID, b, err = unmarshalUint32Safe(b); err != nil { return err }
-	p.Data, b, err = unmarshalStringSafe(b)
+	p.Data, b, err = unmarshalBytesSafe(b)
 	return err
 }
 
+// dataPacketHeaderSize is the size of an SSH_FXP_DATA packet less its data.
+const dataPacketHeaderSize = UINT32_COST + UINT8_COST + UINT32_COST + UINT32_COST
+
+// readDataPacket reads up to length bytes at off straight into a pooled
+// buffer, behind the header of the SSH_FXP_DATA packet that carries them, so
+// the data is not copied again on its way out. It returns how much was read.
+func readDataPacket(id uint32, r io.ReaderAt, off int64, length int) (encodedPacket, int, error) {
+	buf := getPacketBuffer()
+	if cap(*buf) < dataPacketHeaderSize+length {
+		*buf = make([]byte, 0, dataPacketHeaderSize+length)
+	}
+
+	b := (*buf)[:dataPacketHeaderSize+length]
+	n, err := r.ReadAt(b[dataPacketHeaderSize:], off)
+	if err != nil && (err != io.EOF || n == 0) {
+		putPacketBuffer(buf)
+		return encodedPacket{}, n, err
+	}
+
+	b, start := beginPacket(b[:0], SSH_FXP_DATA)
+	b = marshalUint32(b, id)
+	b = marshalUint32(b, uint32(n))
+	*buf = endPacket(b[:len(b)+n], start)
+	return encodedPacket{buf}, n, nil
+}
+
 
 type sshFXPWritePacket struct {
 	sshFXPPacket
 	ID     uint32
 	Handle string
 	Offset uint64
-	Len    uint32
+	Data   []byte
 }
 
+// MarshalBinary encodes the packet straight into a buffer of its exact size,
+// sparing the data the copy out of a pooled buffer marshalPacket makes.
 func (p sshFXPWritePacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_WRITE, p.ID, p.Handle)
+	size := UINT32_COST + UINT8_COST + UINT32_COST + UINT32_COST + len(p.Handle) + UINT64_COST + UINT32_COST + len(p.Data)
+	return p.appendPacket(make([]byte, 0, size)), nil
+}
+
+func (p sshFXPWritePacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_WRITE)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Handle)
 	b = marshalUint64(b, p.Offset)
-	return marshalUint32(b, p.Len), nil
+	return endPacket(marshalBytes(b, p.Data), start)
 }
 
 func (p *sshFXPWritePacket) UnmarshalBinary(b []byte) error {
@@ -248,7 +393,7 @@ func (p *sshFXPWritePacket) UnmarshalBinary(b []byte) error {
 	if p.ID, b, err = unmarshalUint32Safe(b); err != nil { return err }
 	if p.Handle, b, err = unmarshalStringSafe(b); err != nil { return err }
 	if p.Offset, b, err = unmarshalUint64Safe(b); err != nil { return err }
-	p.Len, b, err = unmarshalUint32Safe(b);
+	p.Data, b, err = unmarshalBytesSafe(b)
 	return err
 }
 
@@ -260,9 +405,13 @@ type sshFXPRemovePacket struct {
 }
 
 func (p sshFXPRemovePacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_REMOVE, p.ID, p.Filename)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPRemovePacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_REMOVE)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Filename), nil
+	return endPacket(marshalString(b, p.Filename), start)
 }
 
 func (p *sshFXPRemovePacket) UnmarshalBinary(b []byte) error {
@@ -281,10 +430,14 @@ type sshFXPRenamePacket struct {
 }
 
 func (p sshFXPRenamePacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_RENAME, p.ID, p.OldPath, p.NewPath)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPRenamePacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_RENAME)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.OldPath)
-	return marshalString(b, p.NewPath), nil
+	return endPacket(marshalString(b, p.NewPath), start)
 }
 
 func (p *sshFXPRenamePacket) UnmarshalBinary(b []byte) error {
@@ -303,10 +456,14 @@ type sshFXPMkDirPacket struct {
 }
 
 func (p sshFXPMkDirPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_MKDIR, p.ID, p.Path, p.Attrs)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPMkDirPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_MKDIR)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Path)
-	return marshalFileAttributes(b, p.Attrs), nil
+	return endPacket(marshalFileAttributes(b, p.Attrs), start)
 }
 
 func (p *sshFXPMkDirPacket) UnmarshalBinary(b []byte) error {
@@ -318,6 +475,30 @@ func (p *sshFXPMkDirPacket) UnmarshalBinary(b []byte) error {
 }
 
 
+type sshFXPRmDirPacket struct {
+	sshFXPPacket
+	ID   uint32
+	Path string
+}
+
+func (p sshFXPRmDirPacket) MarshalBinary() ([]byte, error) {
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPRmDirPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_RMDIR)
+	b = marshalUint32(b, p.ID)
+	return endPacket(marshalString(b, p.Path), start)
+}
+
+func (p *sshFXPRmDirPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil { return err }
+	p.Path, b, err = unmarshalStringSafe(b)
+	return err
+}
+
+
 type sshFXPOpenDirPacket struct {
 	sshFXPPacket
 	ID   uint32
@@ -325,9 +506,13 @@ type sshFXPOpenDirPacket struct {
 }
 
 func (p sshFXPOpenDirPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_OPENDIR, p.ID, p.Path)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPOpenDirPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_OPENDIR)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Path), nil
+	return endPacket(marshalString(b, p.Path), start)
 }
 
 func (p *sshFXPOpenDirPacket) UnmarshalBinary(b []byte) error {
@@ -345,9 +530,13 @@ type sshFXPReadDirPacket struct {
 }
 
 func (p sshFXPReadDirPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_READDIR, p.ID, p.Handle)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPReadDirPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_READDIR)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Handle), nil
+	return endPacket(marshalString(b, p.Handle), start)
 }
 
 func (p *sshFXPReadDirPacket) UnmarshalBinary(b []byte) error {
@@ -371,10 +560,14 @@ type sshFXPNamePacket struct {
 }
 
 func (p sshFXPNamePacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_NAME, p.ID, p.Count, p.NamedFiles)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPNamePacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_NAME)
 	b = marshalUint32(b, p.ID)
 	b = marshalUint32(b, p.Count)
-	return marshalNamedFiles(b, p.NamedFiles), nil
+	return endPacket(marshalNamedFiles(b, p.NamedFiles), start)
 }
 
 func (p *sshFXPNamePacket) UnmarshalBinary(b []byte) error {
@@ -393,9 +586,13 @@ type sshFXPStatPacket struct {
 }
 
 func (p sshFXPStatPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_STAT, p.ID, p.Path)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPStatPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_STAT)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Path), nil
+	return endPacket(marshalString(b, p.Path), start)
 }
 
 func (p *sshFXPStatPacket) UnmarshalBinary(b []byte) error {
@@ -413,9 +610,13 @@ type sshFXPLStatPacket struct {
 }
 
 func (p sshFXPLStatPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_LSTAT, p.ID, p.Path)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPLStatPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_LSTAT)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Path), nil
+	return endPacket(marshalString(b, p.Path), start)
 }
 
 func (p *sshFXPLStatPacket) UnmarshalBinary(b []byte) error {
@@ -433,9 +634,13 @@ type sshFXPAttrsPacket struct {
 }
 
 func (p sshFXPAttrsPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_ATTRS, p.ID, p.Attrs)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPAttrsPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_ATTRS)
 	b = marshalUint32(b, p.ID)
-	return marshalFileAttributes(b, p.Attrs), nil
+	return endPacket(marshalFileAttributes(b, p.Attrs), start)
 }
 
 func (p *sshFXPAttrsPacket) UnmarshalBinary(b []byte) error {
@@ -453,9 +658,13 @@ type sshFXPFStatPacket struct {
 }
 
 func (p sshFXPFStatPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_FSTAT, p.ID, p.Handle)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPFStatPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_FSTAT)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Handle), nil
+	return endPacket(marshalString(b, p.Handle), start)
 }
 
 func (p *sshFXPFStatPacket) UnmarshalBinary(b []byte) error {
@@ -474,10 +683,14 @@ type sshFXPSetStatPacket struct {
 }
 
 func (p sshFXPSetStatPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_SETSTAT, p.ID, p.Path, p.Attrs)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPSetStatPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_SETSTAT)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Path)
-	return marshalFileAttributes(b, p.Attrs), nil
+	return endPacket(marshalFileAttributes(b, p.Attrs), start)
 }
 
 func (p *sshFXPSetStatPacket) UnmarshalBinary(b []byte) error {
@@ -497,10 +710,14 @@ type sshFXPFSetStatPacket struct {
 }
 
 func (p sshFXPFSetStatPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_FSETSTAT, p.ID, p.Handle, p.Attrs)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPFSetStatPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_FSETSTAT)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Handle)
-	return marshalFileAttributes(b, p.Attrs), nil
+	return endPacket(marshalFileAttributes(b, p.Attrs), start)
 }
 
 func (p *sshFXPFSetStatPacket) UnmarshalBinary(b []byte) error {
@@ -519,9 +736,13 @@ type sshFXPReadLinkPacket struct {
 }
 
 func (p sshFXPReadLinkPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_READLINK, p.ID, p.Path)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPReadLinkPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_READLINK)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Path), nil
+	return endPacket(marshalString(b, p.Path), start)
 }
 
 func (p *sshFXPReadLinkPacket) UnmarshalBinary(b []byte) error {
@@ -540,10 +761,14 @@ type sshFXPSymlinkPacket struct {
 }
 
 func (p sshFXPSymlinkPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_SYMLINK, p.ID, p.LinkPath, p.TargetPath)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPSymlinkPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_SYMLINK)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.LinkPath)
-	return marshalString(b, p.TargetPath), nil
+	return endPacket(marshalString(b, p.TargetPath), start)
 }
 
 func (p *sshFXPSymlinkPacket) UnmarshalBinary(b []byte) error {
@@ -562,9 +787,13 @@ type sshFXPRealPathPacket struct {
 }
 
 func (p sshFXPRealPathPacket) MarshalBinary() ([]byte, error) {
-	b := makePacketHeader(SSH_FXP_REALPATH, p.ID, p.Path)
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPRealPathPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_REALPATH)
 	b = marshalUint32(b, p.ID)
-	return marshalString(b, p.Path), nil
+	return endPacket(marshalString(b, p.Path), start)
 }
 
 func (p *sshFXPRealPathPacket) UnmarshalBinary(b []byte) error {
@@ -572,4 +801,55 @@ func (p *sshFXPRealPathPacket) UnmarshalBinary(b []byte) error {
 	if p.ID, b, err = unmarshalUint32Safe(b); err != nil { return err }
 	p.Path, b, err = unmarshalStringSafe(b)
 	return err
+}
+
+
+type sshFXPExtendedPacket struct {
+	sshFXPPacket
+	ID              uint32
+	ExtendedRequest string
+	RequestData     []byte
+}
+
+func (p sshFXPExtendedPacket) MarshalBinary() ([]byte, error) {
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPExtendedPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	return endPacket(append(b, p.RequestData...), start)
+}
+
+func (p *sshFXPExtendedPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil { return err }
+	if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil { return err }
+	p.RequestData = b
+	return nil
+}
+
+
+type sshFXPExtendedReplyPacket struct {
+	sshFXPPacket
+	ID   uint32
+	Data []byte
+}
+
+func (p sshFXPExtendedReplyPacket) MarshalBinary() ([]byte, error) {
+	return marshalPacket(p), nil
+}
+
+func (p sshFXPExtendedReplyPacket) appendPacket(b []byte) []byte {
+	b, start := beginPacket(b, SSH_FXP_EXTENDED_REPLY)
+	b = marshalUint32(b, p.ID)
+	return endPacket(append(b, p.Data...), start)
+}
+
+func (p *sshFXPExtendedReplyPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil { return err }
+	p.Data = b
+	return nil
 }
\ No newline at end of file
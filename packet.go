@@ -1,63 +1,82 @@
 package bsftp
 
+import (
+	"encoding"
+	"sync"
+)
+
 const (
 	UINT8_COST = 1
 	UINT32_COST = 4
 	UINT64_COST = 8
 )
 
-func calculatePacketSize(data ...interface{}) uint32 {
-	var size uint32 = 0
-
-	for _, v := range data {
-		switch v.(type) {
-			case byte: size += UINT8_COST
-			case uint32: size += UINT32_COST
-			case int32: size += UINT32_COST
-			case string: size += uint32(UINT32_COST + len(v.(string)))
-			case []extensionPair:
-				for _, ext := range v.([]extensionPair) {
-					size += uint32(UINT32_COST + len(ext.ExtensionName))
-					size += uint32(UINT32_COST + len(ext.ExtensionData))
-				}
-			case fileAttributes:
-				size += UINT32_COST
-				fAttrs := v.(fileAttributes)
-				
-				if fAttrs.Flags&SSH_FILEXFER_ATTR_SIZE == SSH_FILEXFER_ATTR_SIZE {
-					size += UINT64_COST
-				}
-			
-				if fAttrs.Flags&SSH_FILEXFER_ATTR_UIDGID == SSH_FILEXFER_ATTR_UIDGID {
-					size += UINT32_COST * 2
-				}
-			
-				if fAttrs.Flags&SSH_FILEXFER_ATTR_PERMISSIONS == SSH_FILEXFER_ATTR_PERMISSIONS {
-					size += UINT64_COST
-				}
-			
-				if fAttrs.Flags&SSH_FILEXFER_ATTR_ACMODTIME == SSH_FILEXFER_ATTR_ACMODTIME {
-					size += UINT32_COST * 2
-				}
-			case uint64: size += UINT64_COST
-			case int64: size += UINT64_COST
-			case []namedFile:
-				for _, file := range v.([]namedFile) {
-					size += uint32(UINT32_COST + len(file.Filename))
-					size += uint32(UINT32_COST + len(file.Longname))
-					size += calculatePacketSize(file.Attrs)
-				}
-		}
+// packetEncoder is implemented by every packet: appendPacket appends the
+// packet, length prefix and all, to b.
+type packetEncoder interface {
+	encoding.BinaryMarshaler
+	appendPacket(b []byte) []byte
+}
+
+// maxPooledPacketSize bounds the buffers kept for reuse, so that one outsized
+// packet does not pin its memory for the life of the process.
+const maxPooledPacketSize = MaxRxPacketSize + 1024
+
+var packetBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, MaxTxPacketSize)
+		return &b
+	},
+}
+
+func getPacketBuffer() *[]byte {
+	return packetBuffers.Get().(*[]byte)
+}
+
+func putPacketBuffer(b *[]byte) {
+	if cap(*b) <= maxPooledPacketSize {
+		*b = (*b)[:0]
+		packetBuffers.Put(b)
 	}
+}
 
-	return size
+// encodedPacket is a reply encoded as it was built, into a pooled buffer that
+// goes back to the pool once the reply is sent.
+type encodedPacket struct {
+	buf *[]byte
 }
 
-func makePacketHeader(tahyp byte, data ...interface{}) []byte {
-	length := UINT8_COST + calculatePacketSize(data) // to prevent unnecessary array allocation, manually add type field length
-	b := make([]byte, 0, length + UINT32_COST) // the length fields of bidirectional packets do not account for themselves
-	b = marshalUint32(b, length)
-	return marshalByte(b, tahyp)
+func (p encodedPacket) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), *p.buf...), nil
+}
+
+func (p encodedPacket) appendPacket(b []byte) []byte {
+	return append(b, *p.buf...)
+}
+
+// beginPacket appends the header of a packet whose length is not yet known,
+// returning where it starts for endPacket to fill the length in.
+func beginPacket(b []byte, tahyp byte) ([]byte, int) {
+	return append(b, 0, 0, 0, 0, tahyp), len(b)
+}
+
+// endPacket back-patches the length of the packet begun at start. The length
+// field of a packet does not account for itself.
+func endPacket(b []byte, start int) []byte {
+	length := uint32(len(b) - start - UINT32_COST)
+	b[start], b[start+1], b[start+2], b[start+3] = byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)
+	return b
+}
+
+// marshalPacket encodes a packet into a pooled buffer before copying it out,
+// so that the result is allocated once and at its exact size. Packets carrying
+// data size their buffer themselves rather than pay for the copy.
+func marshalPacket(p packetEncoder) []byte {
+	buf := getPacketBuffer()
+	defer putPacketBuffer(buf)
+
+	*buf = p.appendPacket(*buf)
+	return append([]byte(nil), *buf...)
 }
 
 // convert all unmarshal functions to use exploitative memory overflow casting?
@@ -85,6 +104,26 @@ func marshalString(b []byte, v string) []byte {
 	return append(marshalUint32(b, uint32(len(v))), v...)
 }
 
+// marshalBytes marshals b as a string.
+func marshalBytes(b []byte, v []byte) []byte {
+	return append(marshalUint32(b, uint32(len(v))), v...)
+}
+
+// unmarshalBytesSafe unmarshals a string without copying it; the result
+// shares the memory of b.
+func unmarshalBytesSafe(b []byte) ([]byte, []byte, error) {
+	n, b, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if int64(n) > int64(len(b)) {
+		return nil, nil, shortPacketError
+	}
+
+	return b[:n:n], b[n:], nil
+}
+
 func unmarshalStringSafe(b []byte) (string, []byte, error) {
 	n, b, err := unmarshalUint32Safe(b)
 	if err != nil {
@@ -107,6 +146,26 @@ func marshalExtensions(b []byte, v []extensionPair) []byte {
 	return b
 }
 
+func unmarshalExtensionsSafe(b []byte) ([]extensionPair, []byte, error) {
+	var exts []extensionPair
+
+	for len(b) > 0 {
+		var ext extensionPair
+		var err error
+		if ext.ExtensionName, b, err = unmarshalStringSafe(b); err != nil {
+			return nil, nil, err
+		}
+
+		if ext.ExtensionData, b, err = unmarshalStringSafe(b); err != nil {
+			return nil, nil, err
+		}
+
+		exts = append(exts, ext)
+	}
+
+	return exts, b, nil
+}
+
 func marshalByte(b []byte, v byte) []byte {
 	return append(b, v)
 }
@@ -168,29 +227,15 @@ func marshalNamedFiles(b []byte, v []namedFile) []byte {
 }
 
 func unmarshalNamedFilesSafe(b []byte, count uint32) ([]namedFile, []byte, error) {
-	files := make([]namedFile, 0, count)
+	var files []namedFile
 
 	for i := 0; uint32(i) < count; i++ {
-		filename, b, err := unmarshalStringSafe(b)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		longname, b, err := unmarshalStringSafe(b)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		attrs, b, err := unmarshalFileAttributesSafe(b)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		files[i] = namedFile{
-			Filename: filename,
-			Longname: longname,
-			Attrs: attrs,
-		}
+		var file namedFile
+		var err error
+		if file.Filename, b, err = unmarshalStringSafe(b); err != nil { return nil, nil, err }
+		if file.Longname, b, err = unmarshalStringSafe(b); err != nil { return nil, nil, err }
+		if file.Attrs, b, err = unmarshalFileAttributesSafe(b); err != nil { return nil, nil, err }
+		files = append(files, file)
 	}
 
 	return files, b, nil
@@ -0,0 +1,37 @@
+package bsftp
+
+import "testing"
+
+// benchmarkMarshal encodes a packet the way sendPacket does, through
+// appendPacket into a pooled buffer, and the way MarshalBinary does.
+func benchmarkMarshal(b *testing.B, p packetEncoder, size int) {
+	b.Run("appendPacket", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(size))
+		for b.Loop() {
+			buf := getPacketBuffer()
+			*buf = p.appendPacket(*buf)
+			putPacketBuffer(buf)
+		}
+	})
+
+	b.Run("MarshalBinary", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(size))
+		for b.Loop() {
+			if _, err := p.MarshalBinary(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMarshalData(b *testing.B) {
+	data := make([]byte, 32768)
+	benchmarkMarshal(b, sshFXPDataPacket{ID: 1, Data: data}, len(data))
+}
+
+func BenchmarkMarshalWrite(b *testing.B) {
+	data := make([]byte, 32768)
+	benchmarkMarshal(b, sshFXPWritePacket{ID: 1, Handle: "0", Offset: 1 << 20, Data: data}, len(data))
+}
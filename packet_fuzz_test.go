@@ -0,0 +1,171 @@
+package bsftp
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"testing"
+)
+
+// fuzzedPacket is a pointer to a packet that can be both decoded and encoded.
+type fuzzedPacket[T any] interface {
+	*T
+	packetEncoder
+	encoding.BinaryUnmarshaler
+}
+
+// fuzzPacket decodes arbitrary packet bodies, checking that whatever decodes
+// encodes to a packet that decodes and encodes back to the same bytes.
+func fuzzPacket[T any, P fuzzedPacket[T]](f *testing.F, seeds ...T) {
+	tahyp := P(&seeds[0]).appendPacket(nil)[4]
+	f.Add([]byte{})
+	for _, seed := range seeds {
+		f.Add(P(&seed).appendPacket(nil)[5:])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p T
+		if P(&p).UnmarshalBinary(data) != nil {
+			return
+		}
+
+		b, err := P(&p).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(b) < 5 || int(binary.BigEndian.Uint32(b)) != len(b)-4 || b[4] != tahyp {
+			t.Fatalf("Malformed packet encoded: %x", b)
+		}
+
+		var q T
+		if err := P(&q).UnmarshalBinary(b[5:]); err != nil {
+			t.Fatalf("Encoded packet does not decode: %v", err)
+		}
+
+		if c, _ := P(&q).MarshalBinary(); !bytes.Equal(b, c) {
+			t.Fatalf("Packet encoded as %x is encoded again as %x", b, c)
+		}
+	})
+}
+
+var fuzzAttrs = fileAttributes{
+	Flags:    SSH_FILEXFER_ATTR_SIZE | SSH_FILEXFER_ATTR_UIDGID | SSH_FILEXFER_ATTR_PERMISSIONS | SSH_FILEXFER_ATTR_ACMODTIME | SSH_FILEXFER_ATTR_EXTENDED,
+	Stat:     attrs{Size: 1 << 20, UID: 1000, GID: 1000, Permissions: S_IFREG | 0644, ATime: 1700000000, MTime: 1700000001},
+	Extended: []extensionPair{{"acl@example.com", "user::rw-"}},
+}
+
+func FuzzInitPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPInitPacket{Version: 3}, sshFXPInitPacket{Version: 3, Extensions: []extensionPair{{LIMITS_EXTENSION, "1"}}})
+}
+
+func FuzzVersionPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPVersionPacket{Version: 3, Extensions: serverExtensions})
+}
+
+func FuzzOpenPacket(f *testing.F) {
+	fuzzPacket(f,
+		sshFXPOpenPacket{ID: 1, Filename: "/upload.bin", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT | SSH_FXF_TRUNC, Attrs: fuzzAttrs},
+		sshFXPOpenPacket{ID: 2, Filename: "download.bin", PFlags: SSH_FXF_READ},
+	)
+}
+
+func FuzzClosePacket(f *testing.F) {
+	fuzzPacket(f, sshFXPClosePacket{ID: 1, Handle: "0"})
+}
+
+func FuzzReadPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPReadPacket{ID: 1, Handle: "0", Offset: 1 << 32, Len: 32768})
+}
+
+func FuzzWritePacket(f *testing.F) {
+	fuzzPacket(f, sshFXPWritePacket{ID: 1, Handle: "0", Offset: 32768, Data: []byte("contents")})
+}
+
+func FuzzLStatPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPLStatPacket{ID: 1, Path: "/link"})
+}
+
+func FuzzFStatPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPFStatPacket{ID: 1, Handle: "0"})
+}
+
+func FuzzSetStatPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPSetStatPacket{ID: 1, Path: "/file", Attrs: fuzzAttrs})
+}
+
+func FuzzFSetStatPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPFSetStatPacket{ID: 1, Handle: "0", Attrs: fileAttributes{Flags: SSH_FILEXFER_ATTR_PERMISSIONS, Stat: attrs{Permissions: 0600}}})
+}
+
+func FuzzOpenDirPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPOpenDirPacket{ID: 1, Path: "/dir"})
+}
+
+func FuzzReadDirPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPReadDirPacket{ID: 1, Handle: "1"})
+}
+
+func FuzzRemovePacket(f *testing.F) {
+	fuzzPacket(f, sshFXPRemovePacket{ID: 1, Filename: "/file"})
+}
+
+func FuzzMkDirPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPMkDirPacket{ID: 1, Path: "/dir", Attrs: fileAttributes{Flags: SSH_FILEXFER_ATTR_PERMISSIONS, Stat: attrs{Permissions: 0755}}})
+}
+
+func FuzzRmDirPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPRmDirPacket{ID: 1, Path: "/dir"})
+}
+
+func FuzzRealPathPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPRealPathPacket{ID: 1, Path: "."})
+}
+
+func FuzzStatPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPStatPacket{ID: 1, Path: "/file"})
+}
+
+func FuzzRenamePacket(f *testing.F) {
+	fuzzPacket(f, sshFXPRenamePacket{ID: 1, OldPath: "/old", NewPath: "/new"})
+}
+
+func FuzzReadLinkPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPReadLinkPacket{ID: 1, Path: "/link"})
+}
+
+func FuzzSymlinkPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPSymlinkPacket{ID: 1, LinkPath: "file", TargetPath: "/link"})
+}
+
+func FuzzStatusPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPStatusPacket{ID: 1, StatusCode: SSH_FX_NO_SUCH_FILE, ErrorMessage: "No such file", LanguageTag: "en"})
+}
+
+func FuzzHandlePacket(f *testing.F) {
+	fuzzPacket(f, sshFXPHandlePacket{ID: 1, Handle: "0"})
+}
+
+func FuzzDataPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPDataPacket{ID: 1, Data: []byte("contents")})
+}
+
+func FuzzNamePacket(f *testing.F) {
+	fuzzPacket(f, sshFXPNamePacket{ID: 1, Count: 2, NamedFiles: []namedFile{
+		{Filename: "file", Longname: "-rw-r--r--    1 1000     1000      1048576 Nov 14 22:13 file", Attrs: fuzzAttrs},
+		{Filename: "dir", Longname: "drwxr-xr-x    1 1000     1000            0 Nov 14 22:13 dir", Attrs: fileAttributes{Flags: SSH_FILEXFER_ATTR_PERMISSIONS, Stat: attrs{Permissions: S_IFDIR | 0755}}},
+	}})
+}
+
+func FuzzAttrsPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPAttrsPacket{ID: 1, Attrs: fuzzAttrs})
+}
+
+func FuzzExtendedPacket(f *testing.F) {
+	fuzzPacket(f,
+		sshFXPExtendedPacket{ID: 1, ExtendedRequest: STATVFS_EXTENSION, RequestData: marshalString(nil, "/")},
+		sshFXPExtendedPacket{ID: 2, ExtendedRequest: LIMITS_EXTENSION},
+	)
+}
+
+func FuzzExtendedReplyPacket(f *testing.F) {
+	fuzzPacket(f, sshFXPExtendedReplyPacket{ID: 1, Data: marshalUint64(nil, MaxTxPacketSize)})
+}
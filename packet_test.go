@@ -0,0 +1,75 @@
+package bsftp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// A DATA reply read straight into its packet encodes like one built from
+// the data.
+func TestReadDataPacket(t *testing.T) {
+	r := strings.NewReader("0123456789")
+	for _, test := range []struct {
+		off      int64
+		length   int
+		expected string
+	}{
+		{0, 4, "0123"},
+		{3, 4, "3456"},
+		{8, 4, "89"},
+	} {
+		p, n, err := readDataPacket(7, r, test.off, test.length)
+		if err != nil {
+			t.Fatal(err)
+		} else if n != len(test.expected) {
+			t.Fatalf("Expected %d bytes read at %d, read %d", len(test.expected), test.off, n)
+		}
+
+		expected, _ := sshFXPDataPacket{ID: 7, Data: []byte(test.expected)}.MarshalBinary()
+		if !bytes.Equal(*p.buf, expected) {
+			t.Fatalf("Expected %x read at %d, encoded %x", expected, test.off, *p.buf)
+		}
+
+		putPacketBuffer(p.buf)
+	}
+
+	if _, n, err := readDataPacket(7, r, 10, 4); err != io.EOF || n != 0 {
+		t.Fatalf("Expected EOF reading past the end, found %d bytes (%v)", n, err)
+	}
+}
+
+func TestPutPacketBufferKeepsOnlyPoolableBuffers(t *testing.T) {
+	pooled := make([]byte, 10, MaxTxPacketSize)
+	putPacketBuffer(&pooled)
+	if len(pooled) != 0 {
+		t.Fatalf("Expected a pooled buffer emptied, found %d bytes", len(pooled))
+	}
+
+	outsized := make([]byte, 10, maxPooledPacketSize+1)
+	putPacketBuffer(&outsized)
+	if len(outsized) != 10 {
+		t.Fatal("Expected an outsized buffer left to the collector")
+	}
+}
+
+func TestReadPacketReusesBuffer(t *testing.T) {
+	packet, _ := sshFXPStatPacket{ID: 1, Path: "/file"}.MarshalBinary()
+	c := &connection{Reader: bytes.NewReader(packet)}
+
+	buf := make([]byte, 0, 64)
+	b, err := c.readPacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if &b[0] != &buf[:1][0] {
+		t.Fatal("Expected the packet read into the buffer given")
+	} else if !bytes.Equal(b, packet[UINT32_COST:]) {
+		t.Fatalf("Expected %x read, found %x", packet[UINT32_COST:], b)
+	}
+
+	c = &connection{Reader: bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})}
+	if _, err := c.readPacket(buf); err != longPacketError {
+		t.Fatalf("Expected an outsized packet refused, found %v", err)
+	}
+}
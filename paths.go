@@ -0,0 +1,81 @@
+package bsftp
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HomeDirectoryResolver maps a username to that user's home directory,
+// expressed as a path beneath the server's root directory.
+type HomeDirectoryResolver func(username string) (string, error)
+
+// cleanPath canonicalizes an absolute, slash-separated path as seen by the
+// client, collapsing any attempt to climb above the root.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// realPath resolves a client supplied path against the session's working
+// directory, as the client's home directory is its initial working directory.
+func (s *Server) realPath(p string) string {
+	if path.IsAbs(p) {
+		return cleanPath(p)
+	}
+
+	home, err := s.homeDirectory(s.username)
+	if err != nil {
+		home = "/"
+	}
+
+	return cleanPath(path.Join(home, p))
+}
+
+// expandPath behaves like realPath but additionally expands a leading `~' or
+// `~user' into the corresponding home directory.
+func (s *Server) expandPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return s.realPath(p), nil
+	}
+
+	username, rest := p[1:], ""
+	if i := strings.IndexByte(username, '/'); i >= 0 {
+		username, rest = username[:i], username[i+1:]
+	}
+
+	if username == "" {
+		username = s.username
+	}
+
+	home, err := s.homeDirectory(username)
+	if err != nil {
+		return "", err
+	}
+
+	return cleanPath(path.Join(home, rest)), nil
+}
+
+// homeDirectory consults the configured resolver for a user's home directory.
+// Without one, the session user is homed at the root and no one else exists.
+func (s *Server) homeDirectory(username string) (string, error) {
+	if s.homeDirectoryResolver != nil {
+		home, err := s.homeDirectoryResolver(username)
+		if err != nil {
+			return "", err
+		}
+
+		return cleanPath(home), nil
+	}
+
+	if username != s.username {
+		return "", unknownUserError
+	}
+
+	return "/", nil
+}
+
+// localPath maps a client path onto the local filesystem, confining it to the
+// root directory.
+func (s *Server) localPath(p string) string {
+	return filepath.Join(s.rootDirectory, filepath.FromSlash(s.realPath(p)))
+}
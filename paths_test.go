@@ -0,0 +1,69 @@
+package bsftp
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func homeDirectories(username string) (string, error) {
+	switch username {
+		case "bob", "alice": return "/home/" + username, nil
+	}
+
+	return "", unknownUserError
+}
+
+func TestExpandPath(t *testing.T) {
+	client := newTestClient(t, RootDirectory(t.TempDir()), User("bob"), HomeDirectories(homeDirectories))
+	for name, expected := range map[string]string{
+		"~":                "/home/bob",
+		"~/incoming":       "/home/bob/incoming",
+		"~alice/outgoing/": "/home/alice/outgoing",
+		"incoming/../x":    "/home/bob/x",
+		"/~/incoming":      "/~/incoming",
+		"~/../../..":       "/",
+	} {
+		if expanded, err := client.ExpandPath(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if expanded != expected {
+			t.Fatalf("Expected %s to expand to %s, found %s", name, expected, expanded)
+		}
+	}
+
+	var status *StatusError
+	if _, err := client.ExpandPath("~mallory/incoming"); !errors.As(err, &status) || status.Code != SSH_FX_NO_SUCH_FILE {
+		t.Fatalf("Expected SSH_FX_NO_SUCH_FILE for an unknown user, received %v", err)
+	}
+}
+
+func TestExpandPathWithoutResolver(t *testing.T) {
+	client := newTestClient(t, RootDirectory(t.TempDir()), User("bob"))
+	if expanded, err := client.ExpandPath("~/incoming"); err != nil {
+		t.Fatal(err)
+	} else if expanded != "/incoming" {
+		t.Fatalf("Expected the session user homed at the root, found %s", expanded)
+	}
+
+	if _, err := client.ExpandPath("~alice"); err == nil {
+		t.Fatal("Expanded the home directory of a user the session does not know")
+	}
+}
+
+func TestHomeDirectory(t *testing.T) {
+	client := newTestClient(t, RootDirectory(t.TempDir()), User("bob"), HomeDirectories(homeDirectories))
+	for username, expected := range map[string]string{"": "/home/bob", "alice": "/home/alice"} {
+		if home, err := client.HomeDirectory(username); err != nil {
+			t.Fatal(err)
+		} else if home != expected {
+			t.Fatalf("Expected the home directory of %q at %s, found %s", username, expected, home)
+		}
+	}
+
+	// a relative path is resolved against the home directory
+	if name, err := client.RealPath("incoming"); err != nil {
+		t.Fatal(err)
+	} else if name != "/home/bob/incoming" {
+		t.Fatalf("Expected incoming resolved within the home directory, found %s", name)
+	}
+}
@@ -0,0 +1,101 @@
+package bsftp
+
+import "encoding"
+
+// Operation names a class of request subject to an access policy.
+type Operation string
+
+const (
+	OP_READ    Operation = "read"
+	OP_WRITE   Operation = "write"
+	OP_LIST    Operation = "list"
+	OP_STAT    Operation = "stat"
+	OP_DELETE  Operation = "delete"
+	OP_MKDIR   Operation = "mkdir"
+	OP_RENAME  Operation = "rename"
+	OP_SETSTAT Operation = "setstat"
+	OP_SYMLINK Operation = "symlink"
+)
+
+var operations = []Operation{OP_READ, OP_WRITE, OP_LIST, OP_STAT, OP_DELETE, OP_MKDIR, OP_RENAME, OP_SETSTAT, OP_SYMLINK}
+
+// Policy decides whether a user may perform an operation on a path. Paths are
+// canonical and absolute, as seen by the client.
+type Policy interface {
+	Allow(username string, op Operation, path string) bool
+}
+
+type access struct {
+	Op   Operation
+	Path string
+}
+
+// authorize consults the access policy before a request is carried out.
+// Reads and writes on handles were authorized when the handle was opened.
+func (s *Server) authorize(p encoding.BinaryUnmarshaler) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	for _, a := range requestAccesses(p) {
+		if !s.policy.Allow(s.username, a.Op, s.realPath(a.Path)) {
+			return permissionDeniedError
+		}
+	}
+
+	return nil
+}
+
+// authorizeHandle consults the access policy before a request on a handle
+// does more than the handle was opened for.
+func (s *Server) authorizeHandle(f *openFile, op Operation) error {
+	if s.policy != nil && !s.policy.Allow(s.username, op, f.Path) {
+		return permissionDeniedError
+	}
+
+	return nil
+}
+
+// requestAccesses lists every operation a request performs and on what.
+func requestAccesses(p encoding.BinaryUnmarshaler) []access {
+	switch p := p.(type) {
+		case *sshFXPOpenPacket: return openAccesses(p.Filename, p.PFlags)
+		case *sshFXPOpenDirPacket: return []access{{OP_LIST, p.Path}}
+		case *sshFXPStatPacket: return []access{{OP_STAT, p.Path}}
+		case *sshFXPLStatPacket: return []access{{OP_STAT, p.Path}}
+		case *sshFXPSetStatPacket: return []access{{OP_SETSTAT, p.Path}}
+		case *sshFXPRemovePacket: return []access{{OP_DELETE, p.Filename}}
+		case *sshFXPRmDirPacket: return []access{{OP_DELETE, p.Path}}
+		case *sshFXPMkDirPacket: return []access{{OP_MKDIR, p.Path}}
+		case *sshFXPRenamePacket: return []access{{OP_RENAME, p.OldPath}, {OP_RENAME, p.NewPath}}
+		case *sshFXPReadLinkPacket: return []access{{OP_STAT, p.Path}}
+		case *sshFXPSymlinkPacket:
+			link, _ := p.linkAndTarget()
+			return []access{{OP_SYMLINK, link}}
+		case *sshFXPExtendedPacket:
+			name, _, err := unmarshalStringSafe(p.RequestData)
+			if err != nil {
+				break
+			}
+
+			switch p.ExtendedRequest {
+				case LSETSTAT_EXTENSION: return []access{{OP_SETSTAT, name}}
+				case STATVFS_EXTENSION: return []access{{OP_STAT, name}}
+			}
+	}
+
+	return nil
+}
+
+func openAccesses(name string, pflags uint32) []access {
+	var accesses []access
+	if opensForReading(pflags) {
+		accesses = append(accesses, access{OP_READ, name})
+	}
+
+	if opensForWriting(pflags) {
+		accesses = append(accesses, access{OP_WRITE, name})
+	}
+
+	return accesses
+}
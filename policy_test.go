@@ -0,0 +1,77 @@
+package bsftp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// An open asking for neither reading nor writing opens the file for reading,
+// so it must be allowed to read.
+func TestOpenWithoutFlagsNeedsRead(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"secret": "secret"})
+
+	policy := &RulePolicy{Rules: []Rule{{Path: "/**", Allow: []Operation{OP_STAT, OP_LIST}}}}
+	c := newTestConn(t, RootDirectory(root), AccessPolicy(policy))
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPOpenPacket{ID: 1, Filename: "/secret", PFlags: 0}))
+}
+
+func TestOpenWriteOnlyNeedsNoRead(t *testing.T) {
+	policy := &RulePolicy{Rules: []Rule{{Path: "/**", Allow: []Operation{OP_WRITE}}}}
+	c := newTestConn(t, RootDirectory(t.TempDir()), AccessPolicy(policy))
+	c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/upload", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+}
+
+func TestUploadOnlyRefusesOpenWithoutFlags(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"dropped": "dropped"})
+
+	c := newTestConn(t, RootDirectory(root), UploadOnly())
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPOpenPacket{ID: 1, Filename: "/dropped", PFlags: 0}))
+}
+
+// Setting attributes through a handle is authorized like doing so by name,
+// whatever the handle was opened for.
+func TestReadOnlyPolicyRefusesSetStatOnHandles(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	policy := &RulePolicy{Rules: []Rule{{Path: "/**", Allow: []Operation{OP_READ, OP_STAT, OP_LIST}}}}
+	client := newTestClient(t, RootDirectory(root), AccessPolicy(policy))
+
+	f, err := client.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(0); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected truncating refused, found %v", err)
+	} else if err := f.Chmod(0777); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected changing permissions refused, found %v", err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != 8 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the file left alone, found %d bytes with mode %v", fi.Size(), fi.Mode())
+	}
+}
+
+func TestTruncateNeedsWritableHandle(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	c := newTestConn(t, RootDirectory(root))
+
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_READ})
+	truncate := fileAttributes{Flags: SSH_FILEXFER_ATTR_SIZE}
+	expectStatus(t, SSH_FX_PERMISSION_DENIED, c.status(&sshFXPFSetStatPacket{ID: 2, Handle: handle, Attrs: truncate}))
+
+	handle = c.handle(&sshFXPOpenPacket{ID: 3, Filename: "/file", PFlags: SSH_FXF_WRITE})
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPFSetStatPacket{ID: 4, Handle: handle, Attrs: truncate}))
+
+	if fi, err := os.Stat(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != 0 {
+		t.Fatalf("Expected the file truncated, found %d bytes", fi.Size())
+	}
+}
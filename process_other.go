@@ -0,0 +1,17 @@
+//go:build !unix
+
+package bsftp
+
+import "os"
+
+// processExists reports whether a process is running. Where finding a
+// process does not check for it, it is assumed to be.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	p.Release()
+	return true
+}
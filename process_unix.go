@@ -0,0 +1,12 @@
+//go:build unix
+
+package bsftp
+
+import "syscall"
+
+// processExists reports whether a process is running, whether or not it may
+// be signalled.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
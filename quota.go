@@ -0,0 +1,114 @@
+package bsftp
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Quota limits how many bytes and files a user may store. Zero means
+// unlimited.
+type Quota struct {
+	Bytes uint64
+	Files uint64
+}
+
+// QuotaUsage is how many bytes and files a user is charged for.
+type QuotaUsage struct {
+	Bytes uint64
+	Files uint64
+}
+
+// Quotas tracks the usage of every user against their quota. Usage is
+// charged and refunded as requests are carried out rather than measured, so
+// it should be seeded with SetUsage when the server starts. A single Quotas
+// is meant to be shared by every session.
+type Quotas struct {
+	lock   sync.Mutex
+	limits map[string]Quota
+	usage  map[string]QuotaUsage
+}
+
+func NewQuotas() *Quotas {
+	return &Quotas{
+		limits: make(map[string]Quota),
+		usage:  make(map[string]QuotaUsage),
+	}
+}
+
+func (q *Quotas) SetLimit(username string, limit Quota) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.limits[username] = limit
+}
+
+func (q *Quotas) SetUsage(username string, usage QuotaUsage) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.usage[username] = usage
+}
+
+// Usage reports a user's usage along with their limit.
+func (q *Quotas) Usage(username string) (QuotaUsage, Quota) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.usage[username], q.limits[username]
+}
+
+// charge adds to a user's usage, failing without charging anything if that
+// would exceed their quota.
+func (q *Quotas) charge(username string, bytes, files uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	usage, limit := q.usage[username], q.limits[username]
+	if limit.Bytes > 0 && usage.Bytes+bytes > limit.Bytes {
+		return errors.Wrapf(quotaExceededError, "%d of %d bytes used, %d more requested", usage.Bytes, limit.Bytes, bytes)
+	} else if limit.Files > 0 && usage.Files+files > limit.Files {
+		return errors.Wrapf(fileQuotaExceededError, "%d of %d files used", usage.Files, limit.Files)
+	}
+
+	usage.Bytes += bytes
+	usage.Files += files
+	q.usage[username] = usage
+	return nil
+}
+
+// refund takes back a charge, never dropping usage below zero.
+func (q *Quotas) refund(username string, bytes, files uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	usage := q.usage[username]
+	usage.Bytes -= minUint64(usage.Bytes, bytes)
+	usage.Files -= minUint64(usage.Files, files)
+	q.usage[username] = usage
+}
+
+// limitStatVFS shrinks filesystem statistics to what a user's quota leaves
+// them.
+func (q *Quotas) limitStatVFS(username string, st *StatVFS) {
+	usage, limit := q.Usage(username)
+
+	if limit.Bytes > 0 && st.FRSize > 0 {
+		free := (limit.Bytes - minUint64(usage.Bytes, limit.Bytes)) / st.FRSize
+		st.Blocks = minUint64(st.Blocks, limit.Bytes/st.FRSize)
+		st.BFree = minUint64(st.BFree, free)
+		st.BAvail = minUint64(st.BAvail, free)
+	}
+
+	if limit.Files > 0 {
+		free := limit.Files - minUint64(usage.Files, limit.Files)
+		st.Files = minUint64(st.Files, limit.Files)
+		st.FFree = minUint64(st.FFree, free)
+		st.FAvail = minUint64(st.FAvail, free)
+	}
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,40 @@
+package bsftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetStatSizeChargesQuota(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": string(make([]byte, 10))})
+
+	quotas := NewQuotas()
+	quotas.SetLimit("bob", Quota{Bytes: 100, Files: 10})
+	quotas.SetUsage("bob", QuotaUsage{Bytes: 10, Files: 1})
+	c := newTestConn(t, RootDirectory(root), User("bob"), DiskQuotas(quotas))
+
+	resize := func(id uint32, size uint64) uint32 {
+		fAttrs := fileAttributes{Flags: SSH_FILEXFER_ATTR_SIZE}
+		fAttrs.Stat.Size = size
+		return c.status(&sshFXPSetStatPacket{ID: id, Path: "/file", Attrs: fAttrs})
+	}
+
+	if code := resize(1, 1<<30); code == SSH_FX_OK {
+		t.Fatal("Growing a file past the quota succeeded")
+	} else if fi, _ := os.Stat(filepath.Join(root, "file")); fi.Size() != 10 {
+		t.Fatalf("File grown to %d bytes despite the quota", fi.Size())
+	}
+
+	if code := resize(2, 80); code != SSH_FX_OK {
+		t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	} else if usage, _ := quotas.Usage("bob"); usage.Bytes != 80 {
+		t.Fatalf("Expected 80 bytes charged, found %d", usage.Bytes)
+	}
+
+	if code := resize(3, 30); code != SSH_FX_OK {
+		t.Fatalf("Expected SSH_FX_OK, received %s", statusCodeName(code))
+	} else if usage, _ := quotas.Usage("bob"); usage.Bytes != 30 {
+		t.Fatalf("Expected 30 bytes charged, found %d", usage.Bytes)
+	}
+}
@@ -0,0 +1,215 @@
+package bsftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultReplayTimeout = 10 * time.Second
+
+// ReplayMismatchError is a reply that differs from the one captured.
+type ReplayMismatchError struct {
+	Request  TracedPacket
+	Captured TracedPacket
+	Replayed TracedPacket
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("Replied to %s with %s, captured %s", e.Request, e.Replayed, e.Captured)
+}
+
+// Replayer plays the client's side of a captured session to a new server,
+// checking that the server answers every request as the captured server did.
+// Captures may be taken on either side of the connection, but must start
+// with the session.
+//
+// Replies are compared by what a trace describes of them, less status
+// messages, which name the backend's errors in its own words. The contents of
+// reads and writes were redacted when captured, so writes are replayed as
+// zeroes and reads are compared by length only.
+type Replayer struct {
+	// Pace, if positive, holds each request back until as long after the one
+	// before it as in the capture, multiplied by Pace. Otherwise requests are
+	// sent as soon as the replies captured before them have arrived.
+	Pace float64
+	// Timeout is how long to wait for any one reply, ten seconds if unset.
+	Timeout time.Duration
+}
+
+// Replay replays a capture to a server serving an fs.FS, or a root directory,
+// as the options describe. Serving a fstest.MapFS replays read-only sessions
+// entirely in memory; sessions that modify files want a temporary directory.
+func Replay(capture io.Reader, options ...ServerOption) error {
+	return Replayer{}.Replay(capture, options...)
+}
+
+// replyKey tells replies apart: each request is answered under its ID, bar
+// SSH_FXP_INIT, whose SSH_FXP_VERSION answer has none.
+type replyKey struct {
+	version bool
+	id      uint32
+}
+
+func keyOf(p TracedPacket) replyKey {
+	if p.Type == SSH_FXP_INIT || p.Type == SSH_FXP_VERSION {
+		return replyKey{version: true}
+	}
+
+	return replyKey{id: p.ID}
+}
+
+// isReply tells replies from requests.
+func isReply(tahyp byte) bool {
+	return tahyp == SSH_FXP_VERSION || (tahyp >= SSH_FXP_STATUS && tahyp <= SSH_FXP_ATTRS) || tahyp == SSH_FXP_EXTENDED_REPLY
+}
+
+// sameReply compares replies by their descriptions.
+func sameReply(captured, replayed TracedPacket) bool {
+	return captured.Type == replayed.Type &&
+		captured.ID == replayed.ID &&
+		captured.Handle == replayed.Handle &&
+		captured.Path == replayed.Path &&
+		captured.TargetPath == replayed.TargetPath &&
+		captured.Extension == replayed.Extension &&
+		captured.Offset == replayed.Offset &&
+		captured.Length == replayed.Length &&
+		captured.StatusCode == replayed.StatusCode &&
+		captured.Malformed == replayed.Malformed
+}
+
+func (r Replayer) Replay(capture io.Reader, options ...ServerOption) error {
+	reader, err := NewCaptureReader(capture)
+	if err != nil {
+		return err
+	}
+
+	a, b := net.Pipe()
+	server, err := NewServer(a, options...)
+	if err != nil {
+		a.Close()
+		b.Close()
+		return err
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.Serve(context.Background())
+	}()
+
+	defer func() {
+		b.Close()
+		<-served
+	}()
+
+	conn := &connection{Reader: b, WriteCloser: b}
+	replies := make(chan TracedPacket)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(replies)
+
+		var buf []byte
+		for {
+			var err error
+			if buf, err = conn.readPacket(buf); err != nil {
+				return
+			}
+
+			reply := DescribePacket(buf)
+			reply.Direction, reply.Packet = TRACE_SENT, nil
+			select {
+				case replies <- reply:
+				case <-stop: return
+			}
+		}
+	}()
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultReplayTimeout
+	}
+
+	requests := make(map[replyKey]TracedPacket)
+	arrived := make(map[replyKey]TracedPacket)
+	var awaiting []TracedPacket
+
+	// await receives every reply captured so far, comparing each to the
+	// captured one.
+	await := func() error {
+		for _, captured := range awaiting {
+			key := keyOf(captured)
+			timer := time.NewTimer(timeout)
+			for {
+				if replayed, ok := arrived[key]; ok {
+					delete(arrived, key)
+					timer.Stop()
+					if !sameReply(captured, replayed) {
+						return &ReplayMismatchError{Request: requests[key], Captured: captured, Replayed: replayed}
+					}
+
+					break
+				}
+
+				select {
+					case replayed, ok := <-replies:
+						if !ok {
+							timer.Stop()
+							return errors.Errorf("Server closed the connection before replying to %s", requests[key])
+						}
+
+						arrived[keyOf(replayed)] = replayed
+					case <-timer.C: return errors.Errorf("No reply within %s to %s", timeout, requests[key])
+				}
+			}
+		}
+
+		awaiting = awaiting[:0]
+		return nil
+	}
+
+	var frame []byte
+	var previous, sent time.Time
+	for {
+		p, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// whichever side the capture was taken on, describe it as the server
+		// saw it
+		if isReply(p.Type) {
+			p.Direction, p.Packet = TRACE_SENT, nil
+			awaiting = append(awaiting, p)
+			continue
+		}
+
+		if err := await(); err != nil {
+			return err
+		}
+
+		if r.Pace > 0 && !previous.IsZero() {
+			time.Sleep(time.Duration(float64(p.Time.Sub(previous))*r.Pace) - time.Since(sent))
+		}
+
+		frame = marshalUint32(frame[:0], uint32(len(p.Packet)))
+		frame = append(frame, p.Packet...)
+		if err := conn.writePacket(frame); err != nil {
+			return errors.Wrapf(err, "Failed to replay %s", p)
+		}
+
+		previous, sent = p.Time, time.Now()
+		p.Direction, p.Packet = TRACE_RECEIVED, nil
+		requests[keyOf(p)] = p
+	}
+
+	return await()
+}
@@ -0,0 +1,98 @@
+package bsftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+)
+
+// captureSession captures a session reading a file, failing to stat another
+// and listing the root directory.
+func captureSession(t *testing.T) []byte {
+	t.Helper()
+
+	root := newTestRoot(t, map[string]string{"file": string(make([]byte, 1234))})
+
+	var b bytes.Buffer
+	capture := NewCaptureTracer(&b)
+	server, rwc := startServer(t, RootDirectory(root), Trace(capture))
+	client, err := NewClient(rwc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := client.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the file not to exist, received %v", err)
+	} else if _, err := client.ReadDir("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+	<-server.done
+	if err := capture.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+var replayFS = fstest.MapFS{"file": {Data: make([]byte, 1234), Mode: 0644}}
+
+func TestReplay(t *testing.T) {
+	capture := captureSession(t)
+	if err := Replay(bytes.NewReader(capture), FileSystem(replayFS)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayReportsMismatch(t *testing.T) {
+	reader, err := NewCaptureReader(bytes.NewReader(captureSession(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// have the captured server deny the stat the replayed one will find
+	// nothing to answer
+	var altered bytes.Buffer
+	capture := NewCaptureTracer(&altered)
+	for {
+		p, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Type == SSH_FXP_STATUS && p.StatusCode == SSH_FX_NO_SUCH_FILE {
+			binary.BigEndian.PutUint32(p.Packet[UINT8_COST+UINT32_COST:], SSH_FX_PERMISSION_DENIED)
+		}
+
+		capture.Trace(p)
+	}
+
+	if err := capture.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mismatch *ReplayMismatchError
+	err = Replay(bytes.NewReader(altered.Bytes()), FileSystem(replayFS))
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected a mismatch, received %v", err)
+	} else if mismatch.Captured.StatusCode != SSH_FX_PERMISSION_DENIED || mismatch.Replayed.StatusCode != SSH_FX_NO_SUCH_FILE {
+		t.Fatalf("Expected the altered status to mismatch, received %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package bsftp
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TransferOption configures a resumable transfer.
+type TransferOption func(*transfer) error
+
+type transfer struct {
+	progress         func(transferred, total int64)
+	verify           bool
+	files            int
+	deleteExtraneous bool
+}
+
+// Progress calls fn as a transfer advances, with the bytes transferred so far
+// (counting any resumed prefix) out of the total.
+func Progress(fn func(transferred, total int64)) TransferOption {
+	return func(t *transfer) error {
+		t.progress = fn
+		return nil
+	}
+}
+
+// Verify checks that what was transferred before matches the source before
+// resuming, restarting from the beginning if it does not. The check needs the
+// server to support the check-file extension; without it the prefix is
+// trusted.
+func Verify() TransferOption {
+	return func(t *transfer) error {
+		t.verify = true
+		return nil
+	}
+}
+
+func newTransfer(options []TransferOption) (*transfer, error) {
+	t := &transfer{files: 4}
+	for _, option := range options {
+		if err := option(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// progress counts the bytes of a transfer, reporting them as they pass
+// through a wrapped reader or writer.
+type progress struct {
+	transfer    *transfer
+	transferred int64
+	total       int64
+}
+
+func (p *progress) add(n int) {
+	p.transferred += int64(n)
+	if n > 0 && p.transfer.progress != nil {
+		p.transfer.progress(p.transferred, p.total)
+	}
+}
+
+type progressReader struct {
+	io.Reader
+	*progress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	*progress
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.add(n)
+	return n, err
+}
+
+// PutResume uploads a local file, continuing from however much of it the
+// remote file already holds.
+func (c *Client) PutResume(local, remote string, options ...TransferOption) error {
+	t, err := newTransfer(options)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := c.OpenFile(remote, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+
+	dfi, err := dst.Stat()
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	offset, err := c.uploadOffset(t, remote, local, dfi.Size(), fi.Size())
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	if offset == 0 && dfi.Size() > 0 {
+		// whatever is there is not a prefix of the local file
+		if err := dst.Close(); err != nil {
+			return err
+		}
+
+		if dst, err = c.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+			return err
+		}
+	}
+
+	if err := t.copy(dst, src, offset, fi.Size()); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// GetResume downloads a remote file, continuing from however much of it the
+// local file already holds.
+func (c *Client) GetResume(remote, local string, options ...TransferOption) error {
+	t, err := newTransfer(options)
+	if err != nil {
+		return err
+	}
+
+	src, err := c.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	dfi, err := dst.Stat()
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	offset, err := c.resumeOffset(t, src, local, dfi.Size(), fi.Size())
+	if err == nil && offset == 0 {
+		err = dst.Truncate(0)
+	}
+
+	if err == nil {
+		err = t.copy(dst, src, offset, fi.Size())
+	}
+
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// resumeOffset decides where to resume a transfer given how much of it the
+// destination already holds: from there on, or from the beginning if that is
+// no prefix of the source.
+func (c *Client) resumeOffset(t *transfer, remote *File, local string, partial, total int64) (int64, error) {
+	if partial == 0 || partial > total {
+		return 0, nil
+	}
+
+	if t.verify {
+		if ok, err := c.verifyPrefix(remote, local, partial); err != nil {
+			return 0, err
+		} else if !ok {
+			return 0, nil
+		}
+	}
+
+	return partial, nil
+}
+
+// uploadOffset is resumeOffset for an upload, whose destination is open for
+// writing only. The prefix is verified through a handle of its own, and an
+// upload the server will not let be read back, as an upload-only server will
+// not, starts over.
+func (c *Client) uploadOffset(t *transfer, remote, local string, partial, total int64) (int64, error) {
+	if !t.verify || partial == 0 || partial > total {
+		return c.resumeOffset(t, nil, local, partial, total)
+	}
+
+	f, err := c.Open(remote)
+	if errors.Is(err, fs.ErrPermission) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return c.resumeOffset(t, f, local, partial, total)
+}
+
+// verifyPrefix compares the first length bytes of a remote and a local file
+// by their hashes.
+func (c *Client) verifyPrefix(remote *File, local string, length int64) (bool, error) {
+	if _, ok := c.HasExtension(CHECK_FILE_EXTENSION); !ok {
+		return true, nil
+	}
+
+	names := make([]string, 0, len(checkFileHashes))
+	for _, h := range checkFileHashes {
+		names = append(names, h.Name)
+	}
+
+	name, remoteHash, err := remote.checkFile(strings.Join(names, ","), 0, length, 0)
+	if err != nil {
+		return false, err
+	}
+
+	_, newHash, err := checkFileHash(name)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// a single block, so there is no need to bound how many there are
+	localHash, err := hashFileBlocks(f, newHash, 0, length, 0, 0)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(remoteHash, localHash), nil
+}
+
+// copy transfers the source from offset onwards to the same offset of the
+// destination.
+func (t *transfer) copy(dst io.WriteSeeker, src io.ReadSeeker, offset, total int64) error {
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// wrap the local side, leaving the remote File for io.Copy to find its
+	// pipelined ReadFrom or WriteTo
+	p := &progress{transfer: t, transferred: offset, total: total}
+	var err error
+	if _, remote := src.(*File); remote {
+		_, err = io.Copy(progressWriter{Writer: dst, progress: p}, src)
+	} else {
+		_, err = io.Copy(dst, progressReader{Reader: src, progress: p})
+	}
+
+	return err
+}
+
+// checkFile asks the server to hash a range of the file with the first of
+// the algorithms it supports, returning the algorithm and the hashes.
+func (f *File) checkFile(algorithms string, offset, length int64, blockSize uint32) (string, []byte, error) {
+	data := marshalString(nil, f.handle)
+	data = marshalString(data, algorithms)
+	data = marshalUint64(data, uint64(offset))
+	data = marshalUint64(data, uint64(length))
+	data = marshalUint32(data, blockSize)
+
+	id := f.client.newRequestID()
+	reply := sshFXPExtendedReplyPacket{}
+	request := sshFXPExtendedPacket{ID: id, ExtendedRequest: CHECK_FILE_HANDLE_EXTENSION, RequestData: data}
+	if err := f.client.request(id, request, SSH_FXP_EXTENDED_REPLY, &reply); err != nil {
+		return "", nil, err
+	}
+
+	_, b, err := unmarshalStringSafe(reply.Data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, hashes, err := unmarshalStringSafe(b)
+	return name, hashes, err
+}
@@ -0,0 +1,156 @@
+package bsftp
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule grants operations on paths matching a glob pattern. Patterns follow
+// path.Match, with the addition that a `**' element matches any number of
+// path elements. A rule without users applies to everyone; an operation of
+// `*' grants every operation.
+type Rule struct {
+	Users []string    `json:"users,omitempty" yaml:"users,omitempty"`
+	Path  string      `json:"path" yaml:"path"`
+	Allow []Operation `json:"allow" yaml:"allow"`
+}
+
+// RulePolicy is a Policy decided by the first rule matching both the user and
+// the path. Anything not matched by a rule is denied.
+type RulePolicy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadRulePolicy reads a rule policy from a YAML or JSON file, telling them
+// apart by extension.
+func LoadRulePolicy(name string) (*RulePolicy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &RulePolicy{}
+	switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml": err = yaml.Unmarshal(b, policy)
+		default: err = json.Unmarshal(b, policy)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse policy %s", name)
+	} else if err = policy.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid policy %s", name)
+	}
+
+	return policy, nil
+}
+
+// Validate checks every rule for malformed patterns and unknown operations.
+func (p *RulePolicy) Validate() error {
+	for i, rule := range p.Rules {
+		if !path.IsAbs(rule.Path) {
+			return errors.Errorf("Rule %d: path %q is not absolute", i, rule.Path)
+		}
+
+		for _, element := range strings.Split(rule.Path, "/") {
+			if _, err := path.Match(element, ""); err != nil {
+				return errors.Wrapf(err, "Rule %d: bad path %q", i, rule.Path)
+			}
+		}
+
+		for _, username := range rule.Users {
+			if _, err := path.Match(username, ""); err != nil {
+				return errors.Wrapf(err, "Rule %d: bad user %q", i, username)
+			}
+		}
+
+		for _, op := range rule.Allow {
+			if op != "*" && !knownOperation(op) {
+				return errors.Errorf("Rule %d: unknown operation %q", i, op)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *RulePolicy) Allow(username string, op Operation, name string) bool {
+	for _, rule := range p.Rules {
+		if rule.matches(username, name) {
+			return rule.allows(op)
+		}
+	}
+
+	return false
+}
+
+func (r Rule) matches(username, name string) bool {
+	if matched, _ := matchPath(r.Path, name); !matched {
+		return false
+	} else if len(r.Users) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.Users {
+		if matched, _ := path.Match(pattern, username); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Rule) allows(op Operation) bool {
+	for _, allowed := range r.Allow {
+		if allowed == op || allowed == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func knownOperation(op Operation) bool {
+	for _, known := range operations {
+		if op == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPath matches a slash-separated path against a pattern element by
+// element, letting `**' stand for zero or more elements.
+func matchPath(pattern, name string) (bool, error) {
+	return matchElements(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func matchElements(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matched, err := matchElements(pattern[1:], name[i:]); matched || err != nil {
+					return matched, err
+				}
+			}
+
+			return false, nil
+		} else if len(name) == 0 {
+			return false, nil
+		}
+
+		if matched, err := path.Match(pattern[0], name[0]); !matched || err != nil {
+			return false, err
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0, nil
+}
@@ -1,9 +1,16 @@
 package bsftp
 
 import (
+	"context"
+	"encoding"
 	"io"
-	"os"
+	"io/fs"
+	"log/slog"
+	"net"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -13,10 +20,42 @@ const (
 
 type Server struct {
 	*connection
-	openFiles     map[string]*os.File
-	openFilesLock sync.RWMutex
-	handleCount   int
-	rootDirectory string
+	openFiles             map[string]*openFile
+	openFilesLock         sync.RWMutex
+	handleCount           int
+	rootDirectory         string
+	username              string
+	homeDirectoryResolver HomeDirectoryResolver
+	idResolver            IDResolver
+	policy                Policy
+	accessMode            accessMode
+	quotas                *Quotas
+	throttles             *Throttles
+	bandwidth             *Bandwidth
+	userBandwidth         *Bandwidth
+	remoteAddr            net.Addr
+	auditSink             AuditSink
+	metrics               Metrics
+	hooks                 FileHooks
+	atomicUploads         bool
+	partialUploads        PartialUploads
+	fsys                  fs.FS
+	// ctx is cancelled as the session ends, cutting short whatever it is
+	// waiting on
+	ctx                   context.Context
+	cancel                context.CancelFunc
+	shutdownLock          sync.Mutex
+	shuttingDown          bool
+	serving               bool
+	inflight              sync.WaitGroup
+	done                  chan struct{}
+	idleTimeout           time.Duration
+	maxSessionDuration    time.Duration
+	requestTimeout        time.Duration
+	logger                *slog.Logger
+	idleTimer             *time.Timer
+	active                int
+	expiry                error
 }
 
 type ServerOption func(*Server) error
@@ -28,6 +67,90 @@ func RootDirectory(root string) ServerOption {
 	}
 }
 
+// User names the authenticated user this session is serving.
+func User(username string) ServerOption {
+	return func(s *Server) error {
+		s.username = username
+		return nil
+	}
+}
+
+// HomeDirectories sets the resolver used to expand `~' and answer
+// home-directory requests.
+func HomeDirectories(resolver HomeDirectoryResolver) ServerOption {
+	return func(s *Server) error {
+		s.homeDirectoryResolver = resolver
+		return nil
+	}
+}
+
+// IDNames sets the resolver used to name uids and gids, both in listings and
+// in answer to users-groups-by-id requests. It defaults to the local system's
+// user and group databases.
+func IDNames(resolver IDResolver) ServerOption {
+	return func(s *Server) error {
+		s.idResolver = resolver
+		return nil
+	}
+}
+
+// AccessPolicy sets the policy consulted before every request is carried out.
+func AccessPolicy(policy Policy) ServerOption {
+	return func(s *Server) error {
+		s.policy = policy
+		return nil
+	}
+}
+
+// DiskQuotas sets the quotas charged for what the session user stores.
+func DiskQuotas(quotas *Quotas) ServerOption {
+	return func(s *Server) error {
+		s.quotas = quotas
+		return nil
+	}
+}
+
+// Throttle limits the bandwidth of the session, shared with the session
+// user's other sessions and with the rest of the server.
+func Throttle(throttles *Throttles) ServerOption {
+	return func(s *Server) error {
+		s.throttles = throttles
+		return nil
+	}
+}
+
+// RemoteAddr records where the session's client is connecting from.
+func RemoteAddr(addr net.Addr) ServerOption {
+	return func(s *Server) error {
+		s.remoteAddr = addr
+		return nil
+	}
+}
+
+// Audit sets the sink recording every file operation of the session.
+func Audit(sink AuditSink) ServerOption {
+	return func(s *Server) error {
+		s.auditSink = sink
+		return nil
+	}
+}
+
+// Instrument sets where the session reports its metrics.
+func Instrument(metrics Metrics) ServerOption {
+	return func(s *Server) error {
+		s.metrics = metrics
+		return nil
+	}
+}
+
+// Hooks sets the hooks called on the lifecycle of files.
+func Hooks(hooks FileHooks) ServerOption {
+	return func(s *Server) error {
+		s.hooks = hooks
+		return nil
+	}
+}
+
 func NewServer(rwc io.ReadWriteCloser, options ...ServerOption) (*Server, error) {
 	conn := &connection{
 		Reader:      rwc,
@@ -35,8 +158,11 @@ func NewServer(rwc io.ReadWriteCloser, options ...ServerOption) (*Server, error)
 	}
 	server := &Server{
 		connection: conn,
-		openFiles:  make(map[string]*os.File),
+		openFiles:  make(map[string]*openFile),
+		idResolver: systemIDResolver{},
+		done:       make(chan struct{}),
 	}
+	server.ctx, server.cancel = context.WithCancel(context.Background())
 
 	for _, option := range options {
 		if err := option(server); err != nil {
@@ -45,4 +171,339 @@ func NewServer(rwc io.ReadWriteCloser, options ...ServerOption) (*Server, error)
 	}
 
 	return server, nil
-}
\ No newline at end of file
+}
+
+type requestPacket struct {
+	Type byte
+	Data []byte
+	buf  *[]byte
+}
+
+// Serve answers requests until the client disconnects, the context is
+// cancelled or the server is shut down. Cancelling the context drops the
+// connection at once, failing whatever requests are in flight, and Serve
+// returns the context's error; Shutdown lets them finish first.
+func (s *Server) Serve(ctx context.Context) error {
+	s.shutdownLock.Lock()
+	s.serving = true
+	s.shutdownLock.Unlock()
+	defer close(s.done)
+	defer s.cancel()
+
+	// closing the connection ends the read in progress
+	stop := context.AfterFunc(ctx, func() {
+		s.cancel()
+		s.Close()
+	})
+	defer stop()
+
+	err := s.serve()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if expiry := s.expired(); expiry != nil {
+		return expiry
+	} else if s.isShuttingDown() {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown stops the server taking new requests and waits for those in flight
+// to be answered, then closes every open handle and the connection. If the
+// context expires first, they are closed regardless and the context's error
+// is returned. Shutdown also waits for Serve to return. Transfers in flight
+// are no longer held back by bandwidth limits, and requests arriving
+// meanwhile are answered with SSH_FX_FAILURE.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownLock.Lock()
+	s.shuttingDown = true
+	serving := s.serving
+	s.shutdownLock.Unlock()
+	s.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+		case <-drained:
+		case <-ctx.Done(): err = ctx.Err()
+	}
+
+	s.closeHandles(shutdownError)
+	// the client may well have hung up already
+	s.Close()
+
+	if serving {
+		select {
+			case <-s.done:
+			case <-ctx.Done(): err = ctx.Err()
+		}
+	}
+
+	return err
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+
+	return s.shuttingDown
+}
+
+// accept counts a request as in flight, unless the server is shutting down
+// and takes no more.
+func (s *Server) accept() bool {
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	s.inflight.Add(1)
+	if s.active++; s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+
+	return true
+}
+
+// finish marks a request as answered, the session going idle once none are
+// left in flight.
+func (s *Server) finish() {
+	s.shutdownLock.Lock()
+	if s.active--; s.active == 0 && s.idleTimer != nil {
+		s.idleTimer.Reset(s.idleTimeout)
+	}
+	s.shutdownLock.Unlock()
+
+	s.inflight.Done()
+}
+
+// serve answers requests until the connection ends. Requests are handed off
+// to SftpServerWorkerCount workers once the version handshake has completed.
+func (s *Server) serve() error {
+	defer s.startExpiry()()
+
+	if err := s.handshake(); err != nil {
+		return err
+	}
+
+	if s.throttles != nil {
+		s.bandwidth, s.userBandwidth = s.throttles.openSession(s.username)
+		defer s.throttles.closeSession(s.username, s.bandwidth)
+	}
+
+	if s.metrics != nil {
+		s.metrics.AddActiveSessions(1)
+		defer s.metrics.AddActiveSessions(-1)
+	}
+
+	var wg sync.WaitGroup
+	var sendErr error
+	var sendErrOnce sync.Once
+	requests := make(chan requestPacket, SftpServerWorkerCount)
+
+	for i := 0; i < SftpServerWorkerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range requests {
+				if s.metrics != nil {
+					s.metrics.AddQueuedRequests(-1)
+				}
+
+				if err := s.serveRequest(request); err != nil {
+					sendErrOnce.Do(func() {
+						sendErr = err
+						s.Close()
+					})
+				}
+
+				s.finish()
+			}
+		}()
+	}
+
+	var err error
+	for {
+		// requests are read into pooled buffers, given back once answered
+		request := requestPacket{buf: getPacketBuffer()}
+		if *request.buf, err = s.readPacket(*request.buf); err != nil {
+			break
+		}
+
+		request.Type, request.Data = (*request.buf)[0], (*request.buf)[1:]
+		if !s.accept() {
+			// requests go on being read until the connection is closed, so
+			// that each is answered
+			s.refuseRequest(request)
+			continue
+		}
+
+		if s.metrics != nil {
+			s.metrics.AddQueuedRequests(1)
+		}
+
+		requests <- request
+	}
+
+	close(requests)
+	wg.Wait()
+	switch {
+		case s.isShuttingDown(): s.closeHandles(shutdownError)
+		case err == io.EOF: s.closeHandles(disconnectedError)
+		default: s.closeHandles(errors.Wrap(err, "Connection lost"))
+	}
+
+	if sendErr != nil {
+		return sendErr
+	} else if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// refuseRequest answers a request read once the server is shutting down with
+// a failure.
+func (s *Server) refuseRequest(request requestPacket) {
+	defer putPacketBuffer(request.buf)
+
+	if id, _, err := unmarshalUint32Safe(request.Data); err == nil {
+		s.sendPacket(statusFromError(id, shutdownError))
+	}
+}
+
+func (s *Server) handshake() error {
+	tahyp, data, err := s.recvPacket()
+	if err != nil {
+		return err
+	} else if tahyp != SSH_FXP_INIT {
+		return errors.Errorf("Expected SSH_FXP_INIT, received packet type %d", tahyp)
+	}
+
+	init := &sshFXPInitPacket{}
+	if err := init.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	return s.sendPacket(sshFXPVersionPacket{
+		Version:    SFTPProtocolVersionNumber,
+		Extensions: serverExtensions,
+	})
+}
+
+func newRequestPacket(tahyp byte) encoding.BinaryUnmarshaler {
+	switch tahyp {
+		case SSH_FXP_OPEN: return &sshFXPOpenPacket{}
+		case SSH_FXP_CLOSE: return &sshFXPClosePacket{}
+		case SSH_FXP_READ: return &sshFXPReadPacket{}
+		case SSH_FXP_WRITE: return &sshFXPWritePacket{}
+		case SSH_FXP_LSTAT: return &sshFXPLStatPacket{}
+		case SSH_FXP_FSTAT: return &sshFXPFStatPacket{}
+		case SSH_FXP_SETSTAT: return &sshFXPSetStatPacket{}
+		case SSH_FXP_FSETSTAT: return &sshFXPFSetStatPacket{}
+		case SSH_FXP_OPENDIR: return &sshFXPOpenDirPacket{}
+		case SSH_FXP_READDIR: return &sshFXPReadDirPacket{}
+		case SSH_FXP_REMOVE: return &sshFXPRemovePacket{}
+		case SSH_FXP_MKDIR: return &sshFXPMkDirPacket{}
+		case SSH_FXP_RMDIR: return &sshFXPRmDirPacket{}
+		case SSH_FXP_REALPATH: return &sshFXPRealPathPacket{}
+		case SSH_FXP_STAT: return &sshFXPStatPacket{}
+		case SSH_FXP_RENAME: return &sshFXPRenamePacket{}
+		case SSH_FXP_READLINK: return &sshFXPReadLinkPacket{}
+		case SSH_FXP_SYMLINK: return &sshFXPSymlinkPacket{}
+		case SSH_FXP_EXTENDED: return &sshFXPExtendedPacket{}
+	}
+
+	return nil
+}
+
+// serveRequest answers a single request, measuring how long that took. The
+// request's buffer goes back to the pool unless it is still in use.
+func (s *Server) serveRequest(request requestPacket) error {
+	start := time.Now()
+	reply, ok := s.handleWithin(request)
+	if ok {
+		defer putPacketBuffer(request.buf)
+	}
+
+	// a reply encoded as it was built is sent as is
+	buf, ok := reply.(encodedPacket)
+	if !ok {
+		buf = encodedPacket{getPacketBuffer()}
+		*buf.buf = reply.appendPacket(*buf.buf)
+	}
+	defer putPacketBuffer(buf.buf)
+
+	if err := s.writePacket(*buf.buf); err != nil {
+		return err
+	}
+
+	if s.metrics != nil {
+		size := UINT32_COST + UINT8_COST + len(request.Data)
+		s.metrics.ObserveRequest(request.Type, replyStatus(reply), time.Since(start), size, len(*buf.buf))
+	}
+
+	return nil
+}
+
+func (s *Server) handlePacket(ctx context.Context, request requestPacket) packetEncoder {
+	// every request but SSH_FXP_INIT leads with its id
+	id, _, _ := unmarshalUint32Safe(request.Data)
+
+	p := newRequestPacket(request.Type)
+	if p == nil {
+		return sshFXPStatusPacket{ID: id, StatusCode: SSH_FX_OP_UNSUPPORTED, ErrorMessage: "Unsupported packet type", LanguageTag: statusLanguageTag}
+	} else if err := p.UnmarshalBinary(request.Data); err != nil {
+		return statusFromError(id, err)
+	}
+
+	start := time.Now()
+	event := s.auditEvent(p)
+	reply := s.handleRequest(ctx, id, p)
+
+	if event != nil {
+		s.audit(event, reply, start)
+	}
+
+	return reply
+}
+
+func (s *Server) handleRequest(ctx context.Context, id uint32, p encoding.BinaryUnmarshaler) packetEncoder {
+	if err := s.checkAccessMode(p); err != nil {
+		return statusFromError(id, err)
+	} else if err := s.authorize(p); err != nil {
+		return statusFromError(id, err)
+	}
+
+	switch p := p.(type) {
+		case *sshFXPOpenPacket: return s.handleOpen(p)
+		case *sshFXPClosePacket: return s.handleClose(p)
+		case *sshFXPReadPacket: return s.handleRead(ctx, p)
+		case *sshFXPWritePacket: return s.handleWrite(ctx, p)
+		case *sshFXPLStatPacket: return s.handleLStat(p)
+		case *sshFXPFStatPacket: return s.handleFStat(p)
+		case *sshFXPOpenDirPacket: return s.handleOpenDir(p)
+		case *sshFXPReadDirPacket: return s.handleReadDir(p)
+		case *sshFXPSetStatPacket: return s.handleSetStat(p)
+		case *sshFXPFSetStatPacket: return s.handleFSetStat(p)
+		case *sshFXPRemovePacket: return s.handleRemove(p)
+		case *sshFXPMkDirPacket: return s.handleMkDir(p)
+		case *sshFXPRmDirPacket: return s.handleRmDir(p)
+		case *sshFXPRealPathPacket: return s.handleRealPath(p)
+		case *sshFXPStatPacket: return s.handleStat(p)
+		case *sshFXPRenamePacket: return s.handleRename(p)
+		case *sshFXPReadLinkPacket: return s.handleReadLink(p)
+		case *sshFXPSymlinkPacket: return s.handleSymlink(p)
+		case *sshFXPExtendedPacket: return s.handleExtended(p)
+	}
+
+	return sshFXPStatusPacket{ID: id, StatusCode: SSH_FX_OP_UNSUPPORTED, ErrorMessage: "Unsupported packet type", LanguageTag: statusLanguageTag}
+}
@@ -0,0 +1,78 @@
+package bsftp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// Requests arriving while Shutdown waits for those in flight are answered
+// with a failure rather than left unanswered.
+func TestShutdownRefusesLateRequests(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	hooks := FileHooks{OnMkDir: func(event FileEvent) error {
+		close(entered)
+		<-release
+		return nil
+	}}
+
+	server, rwc := startServer(t, RootDirectory(t.TempDir()), Hooks(hooks))
+	c := handshake(t, rwc)
+	if err := c.sendPacket(&sshFXPMkDirPacket{ID: 1, Path: "/dir"}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-entered
+	shutdown := make(chan error)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	for !server.isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+
+	expectStatus(t, SSH_FX_FAILURE, c.status(&sshFXPStatPacket{ID: 2, Path: "/"}))
+
+	close(release)
+	tahyp, data, err := c.recvPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := &sshFXPStatusPacket{}
+	if tahyp != SSH_FXP_STATUS || status.UnmarshalBinary(data) != nil || status.ID != 1 {
+		t.Fatalf("Expected the request in flight answered, received %s", packetTypeName(tahyp))
+	}
+
+	expectStatus(t, SSH_FX_OK, status.StatusCode)
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancellingServeDropsConnection(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	server, err := NewServer(a, RootDirectory(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- server.Serve(ctx) }()
+
+	handshake(t, b)
+	cancel()
+	select {
+		case err := <-served:
+			if err != context.Canceled {
+				t.Fatalf("Expected Serve to return context.Canceled, returned %v", err)
+			}
+		case <-time.After(5 * time.Second): t.Fatal("Serve did not return")
+	}
+
+	if _, err := b.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the connection closed")
+	}
+}
@@ -0,0 +1,97 @@
+package bsftp
+
+import (
+	"os"
+	"time"
+)
+
+// setStatCharged applies attributes to a local file like setStat, charging the
+// session user's quota for whatever a new size grows the file by, and
+// refunding whatever it shrinks it by.
+func (s *Server) setStatCharged(name string, fAttrs fileAttributes, followLinks bool) error {
+	if !followLinks || fAttrs.Flags&SSH_FILEXFER_ATTR_SIZE != SSH_FILEXFER_ATTR_SIZE {
+		return setStat(name, fAttrs, followLinks)
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	var growth, shrinkage uint64
+	if size := uint64(fi.Size()); fAttrs.Stat.Size > size {
+		growth = fAttrs.Stat.Size - size
+	} else {
+		shrinkage = size - fAttrs.Stat.Size
+	}
+
+	if err := s.chargeQuota(growth, 0); err != nil {
+		return err
+	}
+
+	if err := os.Truncate(name, int64(fAttrs.Stat.Size)); err != nil {
+		s.refundQuota(growth, 0)
+		return err
+	}
+
+	s.refundQuota(shrinkage, 0)
+	fAttrs.Flags &^= SSH_FILEXFER_ATTR_SIZE
+	return setStat(name, fAttrs, true)
+}
+
+// lsetStat applies attributes without following a trailing symbolic link.
+// Anything but a link is changed as setStat would change it, as calls that
+// refuse to follow links are not available everywhere even for regular files;
+// only a link itself needs setLinkStat. Like OpenSSH, lsetstat never changes
+// a size.
+func lsetStat(name string, fAttrs fileAttributes) error {
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_SIZE == SSH_FILEXFER_ATTR_SIZE {
+		return unsupportedAttributeError
+	}
+
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return err
+	} else if fi.Mode()&os.ModeSymlink == 0 {
+		return setStat(name, fAttrs, true)
+	}
+
+	return setLinkStat(name, fAttrs)
+}
+
+// setStat applies attributes to a local file. A trailing symbolic link is
+// followed unless followLinks is false, in which case the link itself is
+// changed where the platform allows it.
+func setStat(name string, fAttrs fileAttributes, followLinks bool) error {
+	if !followLinks {
+		return lsetStat(name, fAttrs)
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_SIZE == SSH_FILEXFER_ATTR_SIZE {
+		if err := os.Truncate(name, int64(fAttrs.Stat.Size)); err != nil {
+			return err
+		}
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_PERMISSIONS == SSH_FILEXFER_ATTR_PERMISSIONS {
+		if err := os.Chmod(name, toFileMode(fAttrs.Stat.Permissions)); err != nil {
+			return err
+		}
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_ACMODTIME == SSH_FILEXFER_ATTR_ACMODTIME {
+		atime := time.Unix(int64(fAttrs.Stat.ATime), 0)
+		mtime := time.Unix(int64(fAttrs.Stat.MTime), 0)
+		if err := os.Chtimes(name, atime, mtime); err != nil {
+			return err
+		}
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_UIDGID == SSH_FILEXFER_ATTR_UIDGID {
+		if err := os.Chown(name, int(fAttrs.Stat.UID), int(fAttrs.Stat.GID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
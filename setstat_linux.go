@@ -0,0 +1,39 @@
+//go:build linux
+
+package bsftp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setLinkStat applies attributes to a symbolic link itself. Linux refuses to
+// change a link's permissions, or cannot ask at all before fchmodat2.
+func setLinkStat(name string, fAttrs fileAttributes) error {
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_PERMISSIONS == SSH_FILEXFER_ATTR_PERMISSIONS {
+		if err := unix.Fchmodat(unix.AT_FDCWD, name, fAttrs.Stat.Permissions&07777, unix.AT_SYMLINK_NOFOLLOW); err == unix.EOPNOTSUPP || err == unix.ENOSYS {
+			return unsupportedAttributeError
+		} else if err != nil {
+			return &os.PathError{Op: "fchmodat", Path: name, Err: err}
+		}
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_ACMODTIME == SSH_FILEXFER_ATTR_ACMODTIME {
+		times := []unix.Timespec{
+			{Sec: int64(fAttrs.Stat.ATime)},
+			{Sec: int64(fAttrs.Stat.MTime)},
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, name, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return &os.PathError{Op: "utimensat", Path: name, Err: err}
+		}
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_UIDGID == SSH_FILEXFER_ATTR_UIDGID {
+		if err := os.Lchown(name, int(fAttrs.Stat.UID), int(fAttrs.Stat.GID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package bsftp
+
+import "os"
+
+// setLinkStat applies attributes to a symbolic link itself. Only ownership
+// can be changed portably; everything else is refused.
+func setLinkStat(name string, fAttrs fileAttributes) error {
+	if fAttrs.Flags&(SSH_FILEXFER_ATTR_PERMISSIONS|SSH_FILEXFER_ATTR_ACMODTIME) != 0 {
+		return unsupportedAttributeError
+	}
+
+	if fAttrs.Flags&SSH_FILEXFER_ATTR_UIDGID == SSH_FILEXFER_ATTR_UIDGID {
+		if err := os.Lchown(name, int(fAttrs.Stat.UID), int(fAttrs.Stat.GID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package bsftp
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func lsetStatRequest(id uint32, name string, fAttrs fileAttributes) *sshFXPExtendedPacket {
+	data := marshalString(nil, name)
+	return &sshFXPExtendedPacket{ID: id, ExtendedRequest: LSETSTAT_EXTENSION, RequestData: marshalFileAttributes(data, fAttrs)}
+}
+
+var testMTime = time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+// lsetstat changes files that are no links through the calls setstat uses,
+// as not every kernel can refuse to follow links when changing permissions.
+func TestLSetStatRegularFile(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	c := newTestConn(t, RootDirectory(root))
+
+	fAttrs := fileAttributes{
+		Flags: SSH_FILEXFER_ATTR_PERMISSIONS | SSH_FILEXFER_ATTR_ACMODTIME,
+		Stat:  attrs{Permissions: 0640, ATime: uint32(testMTime.Unix()), MTime: uint32(testMTime.Unix())},
+	}
+	expectStatus(t, SSH_FX_OK, c.status(lsetStatRequest(1, "/file", fAttrs)))
+
+	if fi, err := os.Stat(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0640 || !fi.ModTime().Equal(testMTime) {
+		t.Fatalf("Expected mode 0640 modified at %s, found %v modified at %s", testMTime, fi.Mode().Perm(), fi.ModTime())
+	}
+}
+
+func TestLSetStatLeavesTargetAlone(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Times of links can only be set on Linux")
+	}
+
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	if err := os.Symlink("file", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(filepath.Join(root, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConn(t, RootDirectory(root))
+	fAttrs := fileAttributes{Flags: SSH_FILEXFER_ATTR_ACMODTIME, Stat: attrs{ATime: uint32(testMTime.Unix()), MTime: uint32(testMTime.Unix())}}
+	expectStatus(t, SSH_FX_OK, c.status(lsetStatRequest(1, "/link", fAttrs)))
+
+	if fi, err := os.Lstat(filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(testMTime) {
+		t.Fatalf("Expected the link modified at %s, found %s", testMTime, fi.ModTime())
+	}
+
+	if fi, err := os.Stat(filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(before.ModTime()) {
+		t.Fatal("Target of the link was changed")
+	}
+}
+
+func TestLSetStatRefusesSize(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	c := newTestConn(t, RootDirectory(root))
+	fAttrs := fileAttributes{Flags: SSH_FILEXFER_ATTR_SIZE}
+	expectStatus(t, SSH_FX_OP_UNSUPPORTED, c.status(lsetStatRequest(1, "/file", fAttrs)))
+
+	if b, _ := os.ReadFile(filepath.Join(root, "file")); string(b) != "contents" {
+		t.Fatalf("File truncated to %q", b)
+	}
+}
+
+// Paths climbing above the root land within it.
+func TestLSetStatStaysWithinRoot(t *testing.T) {
+	parent := newTestRoot(t, map[string]string{"outside": "contents", "root/inside": "contents"})
+	c := newTestConn(t, RootDirectory(filepath.Join(parent, "root")))
+
+	fAttrs := fileAttributes{Flags: SSH_FILEXFER_ATTR_PERMISSIONS, Stat: attrs{Permissions: 0604}}
+	expectStatus(t, SSH_FX_NO_SUCH_FILE, c.status(lsetStatRequest(1, "/../outside", fAttrs)))
+	expectStatus(t, SSH_FX_OK, c.status(lsetStatRequest(2, "/../inside", fAttrs)))
+
+	if fi, _ := os.Stat(filepath.Join(parent, "outside")); fi.Mode().Perm() != 0600 {
+		t.Fatalf("File outside the root changed to %v", fi.Mode().Perm())
+	} else if fi, _ := os.Stat(filepath.Join(parent, "root", "inside")); fi.Mode().Perm() != 0604 {
+		t.Fatalf("Expected mode 0604, found %v", fi.Mode().Perm())
+	}
+}
+
+// setstat follows links.
+func TestSetStatFollowsLinks(t *testing.T) {
+	root := newTestRoot(t, map[string]string{"file": "contents"})
+	if err := os.Symlink("file", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConn(t, RootDirectory(root))
+	fAttrs := fileAttributes{Flags: SSH_FILEXFER_ATTR_PERMISSIONS, Stat: attrs{Permissions: 0604}}
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPSetStatPacket{ID: 1, Path: "/link", Attrs: fAttrs}))
+
+	if fi, _ := os.Stat(filepath.Join(root, "file")); fi.Mode().Perm() != 0604 {
+		t.Fatalf("Expected the target changed to mode 0604, found %v", fi.Mode().Perm())
+	}
+}
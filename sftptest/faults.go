@@ -0,0 +1,195 @@
+package sftptest
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+	"github.com/pkg/errors"
+)
+
+// injectedFaultMessage is the message of the statuses FailRequests answers
+// with.
+const injectedFaultMessage = "Injected fault"
+
+// faults disturb the session between the client and the server, the server
+// seeing only the requests that get through.
+type faults struct {
+	failures        map[byte]uint32
+	latency         time.Duration
+	disconnectAfter int
+}
+
+// FailRequests answers every request of the given types with the status code
+// given, in place of the server.
+func FailRequests(code uint32, types ...byte) Option {
+	return func(c *config) {
+		if c.faults.failures == nil {
+			c.faults.failures = make(map[byte]uint32)
+		}
+
+		for _, tahyp := range types {
+			c.faults.failures[tahyp] = code
+		}
+	}
+}
+
+// Latency holds each request back this long before the server sees it.
+func Latency(d time.Duration) Option {
+	return func(c *config) {
+		c.faults.latency = d
+	}
+}
+
+// DisconnectAfter drops the connection once the server has been handed n
+// requests, not counting SSH_FXP_INIT.
+func DisconnectAfter(n int) Option {
+	return func(c *config) {
+		c.faults.disconnectAfter = n
+	}
+}
+
+func (f faults) wrap(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	if f.failures == nil && f.latency <= 0 && f.disconnectAfter <= 0 {
+		return rwc
+	}
+
+	conn := &faultConn{
+		ReadWriteCloser: rwc,
+		faults:          f,
+		frames:          make(chan frame),
+		closed:          make(chan struct{}),
+	}
+
+	go conn.receive()
+	return conn
+}
+
+type frame struct {
+	b       []byte
+	arrived time.Time
+	err     error
+}
+
+// faultConn is the server's end of a connection, disturbed by faults.
+type faultConn struct {
+	io.ReadWriteCloser
+	faults    faults
+	frames    chan frame
+	closed    chan struct{}
+	closeOnce sync.Once
+	writeLock sync.Mutex
+	pending   []byte
+	requests  int
+	err       error
+}
+
+// receive reads whole packets off the connection as they arrive.
+func (f *faultConn) receive() {
+	for {
+		var header [4]byte
+		_, err := io.ReadFull(f.ReadWriteCloser, header[:])
+		var b []byte
+		if err == nil {
+			if length := binary.BigEndian.Uint32(header[:]); length > bsftp.MaxRxPacketSize {
+				err = errors.New("Packet too long")
+			} else {
+				b = make([]byte, len(header)+int(length))
+				copy(b, header[:])
+				_, err = io.ReadFull(f.ReadWriteCloser, b[len(header):])
+			}
+		}
+
+		select {
+			case f.frames <- frame{b: b, arrived: time.Now(), err: err}:
+			case <-f.closed: return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *faultConn) Read(b []byte) (int, error) {
+	for len(f.pending) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		var next frame
+		select {
+			case next = <-f.frames:
+			case <-f.closed: return 0, io.EOF
+		}
+
+		if next.err != nil {
+			f.err = next.err
+			continue
+		}
+
+		time.Sleep(time.Until(next.arrived.Add(f.faults.latency)))
+
+		tahyp := byte(0)
+		if len(next.b) > 4 {
+			tahyp = next.b[4]
+		}
+
+		if tahyp == bsftp.SSH_FXP_INIT {
+			f.pending = next.b
+			break
+		}
+
+		f.requests++
+		if f.faults.disconnectAfter > 0 && f.requests > f.faults.disconnectAfter {
+			f.Close()
+			f.err = io.EOF
+			continue
+		}
+
+		if code, ok := f.faults.failures[tahyp]; ok && len(next.b) >= 9 {
+			if err := f.fail(binary.BigEndian.Uint32(next.b[5:]), code); err != nil {
+				f.err = err
+			}
+
+			continue
+		}
+
+		f.pending = next.b
+	}
+
+	n := copy(b, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// fail answers a request with a status in place of the server.
+func (f *faultConn) fail(id, code uint32) error {
+	b := make([]byte, 4, 4+1+4+4+4+len(injectedFaultMessage)+4)
+	b = append(b, bsftp.SSH_FXP_STATUS)
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = binary.BigEndian.AppendUint32(b, code)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(injectedFaultMessage)))
+	b = append(b, injectedFaultMessage...)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+
+	_, err := f.Write(b)
+	return err
+}
+
+// Write serializes the server's replies with the statuses injected in their
+// place.
+func (f *faultConn) Write(b []byte) (int, error) {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	return f.ReadWriteCloser.Write(b)
+}
+
+func (f *faultConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return f.ReadWriteCloser.Close()
+}
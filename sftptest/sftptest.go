@@ -0,0 +1,193 @@
+// Package sftptest starts bsftp servers in process for tests, each with a
+// client already connected to it.
+//
+//	session := sftptest.New(t, sftptest.Policy(policy), sftptest.FailRequests(bsftp.SSH_FX_FAILURE, bsftp.SSH_FXP_WRITE))
+//	_, err := session.Client.Create("report.csv")
+package sftptest
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"testing"
+	"time"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+)
+
+// shutdownTimeout bounds how long a session's server is given to close its
+// files once the test is over.
+const shutdownTimeout = 5 * time.Second
+
+// Session is a client connected to a server of its own. Both are torn down
+// when the test ends.
+type Session struct {
+	Client *bsftp.Client
+	Server *bsftp.Server
+	// Root is the directory the server serves, unless it serves an fs.FS.
+	Root string
+}
+
+type config struct {
+	root          string
+	fsys          fs.FS
+	username      string
+	overSSH       bool
+	serverOptions []bsftp.ServerOption
+	clientOptions []bsftp.ClientOption
+	faults        faults
+}
+
+type Option func(*config)
+
+// RootDirectory serves a directory of the test's choosing. Sessions otherwise
+// serve a temporary directory of their own.
+func RootDirectory(root string) Option {
+	return func(c *config) {
+		c.root = root
+	}
+}
+
+// Backend serves an fs.FS, such as a fstest.MapFS, in place of a directory.
+// The server is then read-only.
+func Backend(fsys fs.FS) Option {
+	return func(c *config) {
+		c.fsys = fsys
+	}
+}
+
+// User names the user the session serves, "sftptest" by default.
+func User(username string) Option {
+	return func(c *config) {
+		c.username = username
+	}
+}
+
+// Policy sets the access policy consulted before every request.
+func Policy(policy bsftp.Policy) Option {
+	return func(c *config) {
+		c.serverOptions = append(c.serverOptions, bsftp.AccessPolicy(policy))
+	}
+}
+
+// ServerOptions configures the server further, for quotas, hooks, auditing and
+// the like.
+func ServerOptions(options ...bsftp.ServerOption) Option {
+	return func(c *config) {
+		c.serverOptions = append(c.serverOptions, options...)
+	}
+}
+
+// ClientOptions configures the client.
+func ClientOptions(options ...bsftp.ClientOption) Option {
+	return func(c *config) {
+		c.clientOptions = append(c.clientOptions, options...)
+	}
+}
+
+// OverSSH runs the session over an SSH connection, as the sftp subsystem of a
+// session channel, rather than straight over a pipe.
+func OverSSH() Option {
+	return func(c *config) {
+		c.overSSH = true
+	}
+}
+
+// New starts a server and connects a client to it, failing the test if it
+// cannot.
+func New(t testing.TB, options ...Option) *Session {
+	t.Helper()
+
+	c := config{username: "sftptest"}
+	for _, option := range options {
+		option(&c)
+	}
+
+	session := &Session{}
+	serverOptions := []bsftp.ServerOption{bsftp.User(c.username)}
+	if c.fsys != nil {
+		serverOptions = append(serverOptions, bsftp.FileSystem(c.fsys))
+	} else {
+		if c.root == "" {
+			c.root = t.TempDir()
+		}
+
+		session.Root = c.root
+		serverOptions = append(serverOptions, bsftp.RootDirectory(c.root))
+	}
+	serverOptions = append(serverOptions, c.serverOptions...)
+
+	start := startPipe
+	if c.overSSH {
+		start = startSSH
+	}
+
+	served := make(chan struct{})
+	serve := func(rwc io.ReadWriteCloser, extra ...bsftp.ServerOption) (*bsftp.Server, error) {
+		server, err := bsftp.NewServer(c.faults.wrap(rwc), append(serverOptions, extra...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			defer close(served)
+			server.Serve(context.Background())
+		}()
+
+		return server, nil
+	}
+
+	server, rwc, stop, err := start(c.username, serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Server = server
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if session.Client != nil {
+			session.Client.Close()
+		}
+
+		server.Shutdown(ctx)
+		select {
+			case <-served:
+			case <-ctx.Done():
+		}
+
+		stop()
+	})
+
+	session.Client, err = bsftp.NewClient(rwc, c.clientOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+// NewClient starts a server and returns a client connected to it.
+func NewClient(t testing.TB, options ...Option) *bsftp.Client {
+	t.Helper()
+
+	return New(t, options...).Client
+}
+
+// serveFunc starts a server on one end of a connection.
+type serveFunc func(rwc io.ReadWriteCloser, extra ...bsftp.ServerOption) (*bsftp.Server, error)
+
+// startPipe connects a server and a client over an in-memory pipe.
+func startPipe(username string, serve serveFunc) (*bsftp.Server, io.ReadWriteCloser, func(), error) {
+	a, b := net.Pipe()
+	server, err := serve(a, bsftp.RemoteAddr(b.LocalAddr()))
+	if err != nil {
+		a.Close()
+		b.Close()
+		return nil, nil, nil, err
+	}
+
+	return server, b, func() { b.Close() }, nil
+}
@@ -0,0 +1,102 @@
+package sftptest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+	"github.com/pkg/errors"
+)
+
+// roundTrip uploads a file, checks it arrived in the session's root and
+// downloads it again.
+func roundTrip(t *testing.T, session *Session) {
+	t.Helper()
+
+	contents := bytes.Repeat([]byte("contents"), 40000)
+	f, err := session.Client.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.Write(contents); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(session.Root, "file")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, contents) {
+		t.Fatalf("Uploaded %d bytes, found %d", len(contents), len(b))
+	}
+
+	f, err = session.Client.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if b, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, contents) {
+		t.Fatalf("Uploaded %d bytes, downloaded %d", len(contents), len(b))
+	}
+}
+
+func TestRoundTripOverPipe(t *testing.T) {
+	roundTrip(t, New(t))
+}
+
+func TestRoundTripOverSSH(t *testing.T) {
+	roundTrip(t, New(t, OverSSH()))
+}
+
+func TestFailRequests(t *testing.T) {
+	session := New(t, FailRequests(bsftp.SSH_FX_FAILURE, bsftp.SSH_FXP_MKDIR))
+
+	var status *bsftp.StatusError
+	if err := session.Client.Mkdir("/dir"); !errors.As(err, &status) {
+		t.Fatalf("Expected a status, received %v", err)
+	} else if status.Code != bsftp.SSH_FX_FAILURE || status.Message != injectedFaultMessage {
+		t.Fatalf("Expected the injected fault, received %v", status)
+	}
+
+	if _, err := os.Stat(filepath.Join(session.Root, "dir")); !os.IsNotExist(err) {
+		t.Fatal("Failed request reached the server")
+	}
+
+	if _, err := session.Client.Stat("/"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	client := NewClient(t, Latency(latency))
+
+	start := time.Now()
+	if _, err := client.Stat("/"); err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed < latency {
+		t.Fatalf("Expected a reply after %s at the earliest, received one after %s", latency, elapsed)
+	}
+}
+
+func TestDisconnectAfter(t *testing.T) {
+	// the client's query of the server's limits is the first request
+	client := NewClient(t, DisconnectAfter(3))
+	for i := 0; i < 2; i++ {
+		if _, err := client.Stat("/"); err != nil {
+			t.Fatalf("Request %d failed before the disconnect: %v", i+2, err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Stat("/"); err == nil {
+			t.Fatal("Request succeeded after the disconnect")
+		}
+	}
+}
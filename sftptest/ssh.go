@@ -0,0 +1,149 @@
+package sftptest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+
+	bsftp "github.com/bugimetal/bare-sftp"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSH connects a server and a client over an SSH connection on the
+// loopback interface. net.Pipe will not do, as both ends of an SSH connection
+// send their version before reading the other's. The server accepts any
+// client, under a host key of its own.
+func startSSH(username string, serve serveFunc) (*bsftp.Server, io.ReadWriteCloser, func(), error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	servers := make(chan *bsftp.Server, 1)
+	failures := make(chan error, 1)
+	go func() {
+		a, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			failures <- err
+			return
+		}
+
+		conn, channels, requests, err := ssh.NewServerConn(a, config)
+		if err != nil {
+			a.Close()
+			failures <- err
+			return
+		}
+
+		go ssh.DiscardRequests(requests)
+		acceptSessions(conn, channels, serve, servers, failures)
+	}()
+
+	b, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		return nil, nil, nil, err
+	}
+
+	conn, channels, requests, err := ssh.NewClientConn(b, listener.Addr().String(), &ssh.ClientConfig{
+		User:            username,
+		HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+	})
+	if err != nil {
+		b.Close()
+		return nil, nil, nil, err
+	}
+
+	client := ssh.NewClient(conn, channels, requests)
+	rwc, err := openSubsystem(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, nil, err
+	}
+
+	select {
+		case server := <-servers: return server, rwc, func() { client.Close() }, nil
+		case err := <-failures:
+			client.Close()
+			return nil, nil, nil, err
+	}
+}
+
+// acceptSessions serves sftp on every session channel asking for it.
+func acceptSessions(conn *ssh.ServerConn, channels <-chan ssh.NewChannel, serve serveFunc, servers chan<- *bsftp.Server, failures chan<- error) {
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			failures <- err
+			return
+		}
+
+		go func() {
+			for request := range requests {
+				var subsystem struct{ Name string }
+				if request.Type != "subsystem" || ssh.Unmarshal(request.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+					request.Reply(false, nil)
+					continue
+				}
+
+				server, err := serve(channel, bsftp.User(conn.User()), bsftp.RemoteAddr(conn.RemoteAddr()))
+				if err != nil {
+					request.Reply(false, nil)
+					failures <- err
+					continue
+				}
+
+				request.Reply(true, nil)
+				servers <- server
+			}
+		}()
+	}
+}
+
+// openSubsystem opens a session channel and starts sftp on it.
+func openSubsystem(client *ssh.Client) (io.ReadWriteCloser, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, errors.Wrap(err, "Failed to start the sftp subsystem")
+	}
+
+	return struct {
+		io.Reader
+		io.WriteCloser
+	}{r, w}, nil
+}
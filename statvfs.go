@@ -0,0 +1,40 @@
+package bsftp
+
+const (
+	SSH_FXE_STATVFS_ST_RDONLY = 0x1
+	SSH_FXE_STATVFS_ST_NOSUID = 0x2
+)
+
+// StatVFS mirrors the statvfs(3) structure carried by replies to
+// statvfs@openssh.com requests.
+type StatVFS struct {
+	BSize   uint64
+	FRSize  uint64
+	Blocks  uint64
+	BFree   uint64
+	BAvail  uint64
+	Files   uint64
+	FFree   uint64
+	FAvail  uint64
+	FSID    uint64
+	Flag    uint64
+	NameMax uint64
+}
+
+func marshalStatVFS(b []byte, v StatVFS) []byte {
+	for _, field := range []uint64{v.BSize, v.FRSize, v.Blocks, v.BFree, v.BAvail, v.Files, v.FFree, v.FAvail, v.FSID, v.Flag, v.NameMax} {
+		b = marshalUint64(b, field)
+	}
+
+	return b
+}
+
+func unmarshalStatVFSSafe(b []byte) (StatVFS, []byte, error) {
+	var st StatVFS
+	var err error
+	for _, field := range []*uint64{&st.BSize, &st.FRSize, &st.Blocks, &st.BFree, &st.BAvail, &st.Files, &st.FFree, &st.FAvail, &st.FSID, &st.Flag, &st.NameMax} {
+		if *field, b, err = unmarshalUint64Safe(b); err != nil { return st, nil, err }
+	}
+
+	return st, b, nil
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package bsftp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func statFilesystem(name string) (StatVFS, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(name, &st); err != nil {
+		return StatVFS{}, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+
+	return StatVFS{
+		BSize:   uint64(st.Bsize),
+		FRSize:  uint64(st.Frsize),
+		Blocks:  st.Blocks,
+		BFree:   st.Bfree,
+		BAvail:  st.Bavail,
+		Files:   st.Files,
+		FFree:   st.Ffree,
+		FAvail:  st.Ffree,
+		FSID:    uint64(uint32(st.Fsid.Val[0]))<<32 | uint64(uint32(st.Fsid.Val[1])),
+		Flag:    uint64(st.Flags) & (SSH_FXE_STATVFS_ST_RDONLY | SSH_FXE_STATVFS_ST_NOSUID),
+		NameMax: uint64(st.Namelen),
+	}, nil
+}
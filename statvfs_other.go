@@ -0,0 +1,7 @@
+//go:build !linux
+
+package bsftp
+
+func statFilesystem(name string) (StatVFS, error) {
+	return StatVFS{}, unsupportedStatVFSError
+}
@@ -0,0 +1,221 @@
+package bsftp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting throughput to a number of bytes per
+// second, with bursts of up to a second's worth. A rate of zero is unlimited.
+// The rate may be changed at any time, taking effect for the next payload.
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		l.tokens, l.last = float64(bytesPerSecond), time.Now()
+	} else if l.tokens > float64(bytesPerSecond) {
+		l.tokens = float64(bytesPerSecond)
+	}
+
+	l.rate = float64(bytesPerSecond)
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int64(l.rate)
+}
+
+// reserve takes n bytes worth of tokens, going into debt if need be, and
+// returns how long the caller must wait for that debt to be repaid.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Bandwidth pairs the limits on data uploaded and downloaded.
+type Bandwidth struct {
+	Upload   *RateLimiter
+	Download *RateLimiter
+}
+
+func NewBandwidth(upload, download int64) *Bandwidth {
+	return &Bandwidth{
+		Upload:   NewRateLimiter(upload),
+		Download: NewRateLimiter(download),
+	}
+}
+
+func (b *Bandwidth) SetRate(upload, download int64) {
+	b.Upload.SetRate(upload)
+	b.Download.SetRate(download)
+}
+
+// Throttles holds the bandwidth limits shared by every session: one for the
+// whole server, one for each user, and one for each session. Every payload
+// must fit within all three, and any of them may be changed at runtime. A
+// user's limits are forgotten once their last session ends, unless they were
+// given limits of their own.
+type Throttles struct {
+	Global *Bandwidth
+
+	lock            sync.Mutex
+	users           map[string]*Bandwidth
+	userSessions    map[string]int
+	userOverrides   map[string]bool
+	userUpload      int64
+	userDownload    int64
+	sessions        map[*Bandwidth]struct{}
+	sessionUpload   int64
+	sessionDownload int64
+}
+
+func NewThrottles() *Throttles {
+	return &Throttles{
+		Global:        NewBandwidth(0, 0),
+		users:         make(map[string]*Bandwidth),
+		userSessions:  make(map[string]int),
+		userOverrides: make(map[string]bool),
+		sessions:      make(map[*Bandwidth]struct{}),
+	}
+}
+
+// SetUserRate sets the limits shared by each user's sessions, for every user
+// not given limits of their own.
+func (t *Throttles) SetUserRate(upload, download int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for username, b := range t.users {
+		if !t.userOverrides[username] {
+			b.SetRate(upload, download)
+		}
+	}
+
+	t.userUpload, t.userDownload = upload, download
+}
+
+// SetRateForUser gives a user limits of their own, shared by their sessions.
+func (t *Throttles) SetRateForUser(username string, upload, download int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.userOverrides[username] = true
+	t.userBandwidth(username).SetRate(upload, download)
+}
+
+// SetSessionRate sets the limits of every session, including those already
+// connected.
+func (t *Throttles) SetSessionRate(upload, download int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for b := range t.sessions {
+		b.SetRate(upload, download)
+	}
+
+	t.sessionUpload, t.sessionDownload = upload, download
+}
+
+func (t *Throttles) userBandwidth(username string) *Bandwidth {
+	b, ok := t.users[username]
+	if !ok {
+		b = NewBandwidth(t.userUpload, t.userDownload)
+		t.users[username] = b
+	}
+
+	return b
+}
+
+// openSession returns the limits of a new session of the user's, and those
+// the user's sessions share.
+func (t *Throttles) openSession(username string) (*Bandwidth, *Bandwidth) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	b := NewBandwidth(t.sessionUpload, t.sessionDownload)
+	t.sessions[b] = struct{}{}
+	t.userSessions[username]++
+	return b, t.userBandwidth(username)
+}
+
+func (t *Throttles) closeSession(username string, b *Bandwidth) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.sessions, b)
+	if t.userSessions[username]--; t.userSessions[username] <= 0 {
+		delete(t.userSessions, username)
+		if !t.userOverrides[username] {
+			delete(t.users, username)
+		}
+	}
+}
+
+// throttle blocks until n bytes fit within every one of the limiters, or the
+// context is done.
+func throttle(ctx context.Context, n int, limiters ...*RateLimiter) {
+	var delay time.Duration
+	for _, l := range limiters {
+		if d := l.reserve(n); d > delay {
+			delay = d
+		}
+	}
+
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+		case <-timer.C:
+		case <-ctx.Done():
+	}
+}
+
+func (s *Server) throttleUpload(ctx context.Context, n int) {
+	if s.throttles != nil {
+		throttle(ctx, n, s.bandwidth.Upload, s.userBandwidth.Upload, s.throttles.Global.Upload)
+	}
+}
+
+func (s *Server) throttleDownload(ctx context.Context, n int) {
+	if s.throttles != nil {
+		throttle(ctx, n, s.bandwidth.Download, s.userBandwidth.Download, s.throttles.Global.Download)
+	}
+}
@@ -0,0 +1,116 @@
+package bsftp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstsThenWaits(t *testing.T) {
+	l := NewRateLimiter(1000)
+	if d := l.reserve(1000); d != 0 {
+		t.Fatalf("Expected a second's worth to pass at once, waited %s", d)
+	}
+
+	if d := l.reserve(500); d < 450*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("Expected to wait about half a second, waited %s", d)
+	}
+
+	l.SetRate(0)
+	if d := l.reserve(1 << 30); d != 0 {
+		t.Fatalf("Expected no wait without a limit, waited %s", d)
+	}
+}
+
+// A transfer held back by its limits is let go when the server shuts down.
+func TestShutdownInterruptsThrottledTransfer(t *testing.T) {
+	throttles := NewThrottles()
+	throttles.SetSessionRate(1000, 0)
+
+	server, rwc := startServer(t, RootDirectory(t.TempDir()), Throttle(throttles))
+	c := handshake(t, rwc)
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+
+	// a minute's worth, past the second's burst
+	if err := c.sendPacket(&sshFXPWritePacket{ID: 2, Handle: handle, Data: make([]byte, 60000)}); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := make(chan uint32, 1)
+	go func() {
+		_, data, err := c.recvPacket()
+		status := &sshFXPStatusPacket{}
+		if err == nil && status.UnmarshalBinary(data) == nil {
+			replies <- status.StatusCode
+		}
+		close(replies)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Shutdown waited %s on a throttled transfer", elapsed)
+	}
+
+	if code, ok := <-replies; !ok {
+		t.Fatal("Throttled transfer was never answered")
+	} else {
+		expectStatus(t, SSH_FX_OK, code)
+	}
+}
+
+// A transfer given up on by the request timeout stops waiting on its limits,
+// letting go of its handle.
+func TestRequestTimeoutInterruptsThrottledTransfer(t *testing.T) {
+	throttles := NewThrottles()
+	throttles.SetUserRate(1000, 0)
+
+	c := newTestConn(t, RootDirectory(t.TempDir()), Throttle(throttles), RequestTimeout(100*time.Millisecond))
+	handle := c.handle(&sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_WRITE | SSH_FXF_CREAT})
+	expectStatus(t, SSH_FX_FAILURE, c.status(&sshFXPWritePacket{ID: 2, Handle: handle, Data: make([]byte, 60000)}))
+
+	// lifting the limit lets the next write through, which it could not if
+	// the last one still held the handle
+	throttles.SetUserRate(0, 0)
+	start := time.Now()
+	expectStatus(t, SSH_FX_OK, c.status(&sshFXPWritePacket{ID: 3, Handle: handle, Data: []byte("contents")}))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Write waited %s on a transfer given up on", elapsed)
+	}
+}
+
+// Users are forgotten along with their last session, bar those given limits of
+// their own.
+func TestThrottlesForgetUsersWithoutSessions(t *testing.T) {
+	throttles := NewThrottles()
+	throttles.SetRateForUser("alice", 100, 100)
+
+	users := func() int {
+		throttles.lock.Lock()
+		defer throttles.lock.Unlock()
+		return len(throttles.users)
+	}
+
+	first, bob := throttles.openSession("bob")
+	second, shared := throttles.openSession("bob")
+	if shared != bob || second == first {
+		t.Fatal("Sessions of one user do not share their limits")
+	} else if users() != 2 {
+		t.Fatalf("Expected 2 users, found %d", users())
+	}
+
+	throttles.closeSession("bob", first)
+	if users() != 2 {
+		t.Fatal("User forgotten while a session remains")
+	}
+
+	throttles.closeSession("bob", second)
+	if users() != 1 {
+		t.Fatalf("Expected only alice remembered, found %d users", users())
+	}
+}
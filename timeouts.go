@@ -0,0 +1,167 @@
+package bsftp
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// IdleTimeout expires a session once it has gone this long without a request
+// to answer, so that abandoned sessions do not hold their files open.
+func IdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return invalidTimeoutError
+		}
+
+		s.idleTimeout = d
+		return nil
+	}
+}
+
+// MaxSessionDuration expires a session this long after it started, busy or
+// not.
+func MaxSessionDuration(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return invalidTimeoutError
+		}
+
+		s.maxSessionDuration = d
+		return nil
+	}
+}
+
+// RequestTimeout bounds how long the server waits on its backend for any one
+// request, failing the request once it is up. Filesystem calls cannot be
+// interrupted, so one that times out runs on, its outcome discarded.
+func RequestTimeout(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return invalidTimeoutError
+		}
+
+		s.requestTimeout = d
+		return nil
+	}
+}
+
+// Logger sets where the session logs why it expired or a request timed out.
+// It defaults to slog's default logger.
+func Logger(logger *slog.Logger) ServerOption {
+	return func(s *Server) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+func (s *Server) log() *slog.Logger {
+	if s.logger != nil {
+		return s.logger
+	}
+
+	return slog.Default()
+}
+
+func (s *Server) sessionAttrs(attrs ...slog.Attr) []slog.Attr {
+	attrs = append(attrs, slog.String("user", s.username))
+	if s.remoteAddr != nil {
+		attrs = append(attrs, slog.String("remote_addr", s.remoteAddr.String()))
+	}
+
+	return attrs
+}
+
+// startExpiry arms the session's timers, returning a function disarming them.
+func (s *Server) startExpiry() func() {
+	var maxTimer *time.Timer
+	if s.maxSessionDuration > 0 {
+		maxTimer = time.AfterFunc(s.maxSessionDuration, func() { s.expire(sessionDurationError) })
+	}
+
+	s.shutdownLock.Lock()
+	if s.idleTimeout > 0 {
+		s.idleTimer = time.AfterFunc(s.idleTimeout, func() { s.expire(idleTimeoutError) })
+	}
+	s.shutdownLock.Unlock()
+
+	return func() {
+		if maxTimer != nil {
+			maxTimer.Stop()
+		}
+
+		s.shutdownLock.Lock()
+		defer s.shutdownLock.Unlock()
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+			s.idleTimer = nil
+		}
+	}
+}
+
+// expire ends the session for the reason given, closing its handles and the
+// connection.
+func (s *Server) expire(reason error) {
+	s.shutdownLock.Lock()
+	if s.expiry != nil || s.shuttingDown {
+		s.shutdownLock.Unlock()
+		return
+	}
+	s.expiry = reason
+	s.shutdownLock.Unlock()
+	s.cancel()
+
+	s.log().LogAttrs(context.Background(), slog.LevelInfo, "sftp session expired", s.sessionAttrs(slog.String("reason", reason.Error()))...)
+	s.closeHandles(reason)
+	s.Close()
+}
+
+// expired is why the session expired, if it did.
+func (s *Server) expired() error {
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+
+	return s.expiry
+}
+
+// handleWithin answers a request, giving up on it after the request timeout.
+// A request given up on is left to finish in the background, and keeps its
+// buffer until it does; ok reports whether its buffer may be reused.
+func (s *Server) handleWithin(request requestPacket) (reply packetEncoder, ok bool) {
+	if s.requestTimeout <= 0 {
+		return s.handlePacket(s.ctx, request), true
+	}
+
+	// a request given up on stops waiting on bandwidth limits
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	replies := make(chan packetEncoder, 1)
+	go func() {
+		replies <- s.handlePacket(ctx, request)
+	}()
+
+	timer := time.NewTimer(s.requestTimeout)
+	defer timer.Stop()
+
+	select {
+		case reply := <-replies: return reply, true
+		case <-timer.C:
+	}
+
+	go func() {
+		if reply, ok := (<-replies).(encodedPacket); ok {
+			putPacketBuffer(reply.buf)
+		}
+
+		putPacketBuffer(request.buf)
+	}()
+
+	s.log().LogAttrs(context.Background(), slog.LevelWarn, "sftp request timed out", s.sessionAttrs(
+		slog.String("request", packetTypeName(request.Type)),
+		slog.Duration("timeout", s.requestTimeout),
+	)...)
+
+	id, _, _ := unmarshalUint32Safe(request.Data)
+	return statusFromError(id, requestTimeoutError), false
+}
@@ -0,0 +1,91 @@
+package bsftp
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func waitForExpiry(t *testing.T, server *Server, expected error) {
+	t.Helper()
+
+	select {
+		case <-server.done:
+		case <-time.After(5 * time.Second): t.Fatal("Session did not expire")
+	}
+
+	if expiry := server.expired(); expiry != expected {
+		t.Fatalf("Expected the session to expire with %q, found %v", expected, expiry)
+	}
+}
+
+// A request in flight keeps the session from going idle, however long it
+// takes.
+func TestIdleTimeoutWaitsForRequestsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	hooks := FileHooks{OnMkDir: func(event FileEvent) error {
+		<-release
+		return nil
+	}}
+
+	server, rwc := startServer(t, RootDirectory(t.TempDir()), IdleTimeout(50*time.Millisecond), Hooks(hooks), Logger(discardLogger))
+	c := handshake(t, rwc)
+	if err := c.sendPacket(&sshFXPMkDirPacket{ID: 1, Path: "/dir"}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if expiry := server.expired(); expiry != nil {
+		t.Fatalf("Expected the session kept while busy, expired with %v", expiry)
+	}
+
+	close(release)
+	if tahyp, _, err := c.recvPacket(); err != nil || tahyp != SSH_FXP_STATUS {
+		t.Fatalf("Expected the request answered, received %s (%v)", packetTypeName(tahyp), err)
+	}
+
+	waitForExpiry(t, server, idleTimeoutError)
+}
+
+func TestMaxSessionDurationExpiresBusySession(t *testing.T) {
+	server, rwc := startServer(t, RootDirectory(t.TempDir()), MaxSessionDuration(100*time.Millisecond), Logger(discardLogger))
+	c := handshake(t, rwc)
+
+	go func() {
+		for id := uint32(1); ; id++ {
+			if err := c.sendPacket(&sshFXPStatPacket{ID: id, Path: "/"}); err != nil {
+				return
+			} else if _, _, err := c.recvPacket(); err != nil {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	waitForExpiry(t, server, sessionDurationError)
+}
+
+func TestRequestTimeoutFailsSlowRequests(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hooks := FileHooks{OnMkDir: func(event FileEvent) error {
+		<-release
+		return nil
+	}}
+
+	c := newTestConn(t, RootDirectory(t.TempDir()), RequestTimeout(50*time.Millisecond), Hooks(hooks), Logger(discardLogger))
+	expectStatus(t, SSH_FX_FAILURE, c.status(&sshFXPMkDirPacket{ID: 1, Path: "/dir"}))
+	expectStatus(t, SSH_FX_NO_SUCH_FILE, c.status(&sshFXPStatPacket{ID: 2, Path: "/missing"}))
+}
+
+func TestTimeoutsMustBePositive(t *testing.T) {
+	for _, option := range []ServerOption{IdleTimeout(0), MaxSessionDuration(-time.Second), RequestTimeout(0)} {
+		if _, err := NewServer(nil, option); err != invalidTimeoutError {
+			t.Fatalf("Expected %v, found %v", invalidTimeoutError, err)
+		}
+	}
+}
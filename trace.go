@@ -0,0 +1,245 @@
+package bsftp
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TraceDirection tells whether a traced packet was received or sent.
+type TraceDirection byte
+
+const (
+	TRACE_RECEIVED TraceDirection = iota
+	TRACE_SENT
+)
+
+func (d TraceDirection) String() string {
+	if d == TRACE_SENT {
+		return "sent"
+	}
+
+	return "received"
+}
+
+// TracedPacket describes a packet as it crossed the connection. Payloads are
+// never described, only their lengths.
+type TracedPacket struct {
+	Time          time.Time
+	Direction     TraceDirection
+	Type          byte
+	ID            uint32
+	Handle        string
+	Path          string
+	TargetPath    string
+	Extension     string
+	Offset        uint64
+	Length        uint64
+	StatusCode    uint32
+	StatusMessage string
+	// the packet could not be decoded
+	Malformed bool
+	// the packet itself, type and body, which is only valid for the duration
+	// of Trace
+	Packet []byte
+}
+
+// Tracer is handed every packet a connection receives or sends.
+type Tracer interface {
+	Trace(p TracedPacket)
+}
+
+// Trace has the server hand every packet of the session to the tracers.
+func Trace(tracers ...Tracer) ServerOption {
+	return func(s *Server) error {
+		s.tracers = append(s.tracers, tracers...)
+		return nil
+	}
+}
+
+// ClientTrace has the client hand every packet it receives or sends to the
+// tracers.
+func ClientTrace(tracers ...Tracer) ClientOption {
+	return func(c *Client) error {
+		c.tracers = append(c.tracers, tracers...)
+		return nil
+	}
+}
+
+// trace hands a packet, less its length, to the connection's tracers.
+func (c *connection) trace(direction TraceDirection, b []byte) {
+	if len(c.tracers) == 0 {
+		return
+	}
+
+	p := DescribePacket(b)
+	p.Time, p.Direction = time.Now(), direction
+	for _, tracer := range c.tracers {
+		tracer.Trace(p)
+	}
+}
+
+// DescribePacket decodes a packet, type and body, into a TracedPacket.
+func DescribePacket(b []byte) TracedPacket {
+	p := TracedPacket{Packet: b}
+	if len(b) == 0 {
+		p.Malformed = true
+		return p
+	}
+
+	p.Type = b[0]
+	packet := newTracedPacket(p.Type)
+	if packet == nil {
+		p.ID, _, _ = unmarshalUint32Safe(b[1:])
+		return p
+	} else if err := packet.UnmarshalBinary(b[1:]); err != nil {
+		p.ID, _, _ = unmarshalUint32Safe(b[1:])
+		p.Malformed = true
+		return p
+	}
+
+	switch packet := packet.(type) {
+		case *sshFXPInitPacket: p.Length = uint64(packet.Version)
+		case *sshFXPVersionPacket: p.Length = uint64(packet.Version)
+		case *sshFXPOpenPacket: p.ID, p.Path = packet.ID, packet.Filename
+		case *sshFXPClosePacket: p.ID, p.Handle = packet.ID, packet.Handle
+		case *sshFXPReadPacket: p.ID, p.Handle, p.Offset, p.Length = packet.ID, packet.Handle, packet.Offset, uint64(packet.Len)
+		case *sshFXPWritePacket: p.ID, p.Handle, p.Offset, p.Length = packet.ID, packet.Handle, packet.Offset, uint64(len(packet.Data))
+		case *sshFXPLStatPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPFStatPacket: p.ID, p.Handle = packet.ID, packet.Handle
+		case *sshFXPSetStatPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPFSetStatPacket: p.ID, p.Handle = packet.ID, packet.Handle
+		case *sshFXPOpenDirPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPReadDirPacket: p.ID, p.Handle = packet.ID, packet.Handle
+		case *sshFXPRemovePacket: p.ID, p.Path = packet.ID, packet.Filename
+		case *sshFXPMkDirPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPRmDirPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPRealPathPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPStatPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPRenamePacket: p.ID, p.Path, p.TargetPath = packet.ID, packet.OldPath, packet.NewPath
+		case *sshFXPReadLinkPacket: p.ID, p.Path = packet.ID, packet.Path
+		case *sshFXPSymlinkPacket:
+			link, target := packet.linkAndTarget()
+			p.ID, p.Path, p.TargetPath = packet.ID, link, target
+		case *sshFXPStatusPacket: p.ID, p.StatusCode, p.StatusMessage = packet.ID, packet.StatusCode, packet.ErrorMessage
+		case *sshFXPHandlePacket: p.ID, p.Handle = packet.ID, packet.Handle
+		case *sshFXPDataPacket: p.ID, p.Length = packet.ID, uint64(len(packet.Data))
+		case *sshFXPNamePacket:
+			p.ID, p.Length = packet.ID, uint64(packet.Count)
+			if len(packet.NamedFiles) == 1 {
+				p.Path = packet.NamedFiles[0].Filename
+			}
+		case *sshFXPAttrsPacket: p.ID = packet.ID
+		case *sshFXPExtendedReplyPacket: p.ID, p.Length = packet.ID, uint64(len(packet.Data))
+		case *sshFXPExtendedPacket:
+			p.ID, p.Extension = packet.ID, packet.ExtendedRequest
+			name, _, _ := unmarshalStringSafe(packet.RequestData)
+			switch packet.ExtendedRequest {
+				case EXPAND_PATH_EXTENSION, LSETSTAT_EXTENSION, STATVFS_EXTENSION, CHECK_FILE_EXTENSION: p.Path = name
+				case CHECK_FILE_HANDLE_EXTENSION: p.Handle = name
+			}
+	}
+
+	return p
+}
+
+func newTracedPacket(tahyp byte) encoding.BinaryUnmarshaler {
+	switch tahyp {
+		case SSH_FXP_INIT: return &sshFXPInitPacket{}
+		case SSH_FXP_VERSION: return &sshFXPVersionPacket{}
+		case SSH_FXP_STATUS: return &sshFXPStatusPacket{}
+		case SSH_FXP_HANDLE: return &sshFXPHandlePacket{}
+		case SSH_FXP_DATA: return &sshFXPDataPacket{}
+		case SSH_FXP_NAME: return &sshFXPNamePacket{}
+		case SSH_FXP_ATTRS: return &sshFXPAttrsPacket{}
+		case SSH_FXP_EXTENDED_REPLY: return &sshFXPExtendedReplyPacket{}
+	}
+
+	return newRequestPacket(tahyp)
+}
+
+// String describes the packet on a single line, leaving out what it does not
+// carry.
+func (p TracedPacket) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", p.Direction, packetTypeName(p.Type))
+	if p.Type == SSH_FXP_INIT || p.Type == SSH_FXP_VERSION {
+		fmt.Fprintf(&b, " version=%d", p.Length)
+	} else {
+		fmt.Fprintf(&b, " id=%d", p.ID)
+	}
+
+	if p.Extension != "" {
+		fmt.Fprintf(&b, " extension=%q", p.Extension)
+	}
+
+	if p.Handle != "" {
+		fmt.Fprintf(&b, " handle=%q", p.Handle)
+	}
+
+	if p.Path != "" {
+		fmt.Fprintf(&b, " path=%q", p.Path)
+	}
+
+	if p.TargetPath != "" {
+		fmt.Fprintf(&b, " target=%q", p.TargetPath)
+	}
+
+	switch p.Type {
+		case SSH_FXP_READ, SSH_FXP_WRITE: fmt.Fprintf(&b, " offset=%d len=%d", p.Offset, p.Length)
+		case SSH_FXP_DATA, SSH_FXP_EXTENDED_REPLY: fmt.Fprintf(&b, " len=%d", p.Length)
+		case SSH_FXP_NAME: fmt.Fprintf(&b, " count=%d", p.Length)
+		case SSH_FXP_STATUS: fmt.Fprintf(&b, " status=%s message=%q", statusCodeName(p.StatusCode), p.StatusMessage)
+	}
+
+	if p.Malformed {
+		b.WriteString(" malformed")
+	}
+
+	return b.String()
+}
+
+// SlogTracer logs each packet through a structured logger.
+type SlogTracer struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func (l SlogTracer) Trace(p TracedPacket) {
+	attrs := []slog.Attr{
+		slog.String("direction", p.Direction.String()),
+		slog.String("type", packetTypeName(p.Type)),
+		slog.Uint64("id", uint64(p.ID)),
+	}
+
+	if p.Extension != "" {
+		attrs = append(attrs, slog.String("extension", p.Extension))
+	}
+
+	if p.Handle != "" {
+		attrs = append(attrs, slog.String("handle", p.Handle))
+	}
+
+	if p.Path != "" {
+		attrs = append(attrs, slog.String("path", p.Path))
+	}
+
+	if p.TargetPath != "" {
+		attrs = append(attrs, slog.String("target_path", p.TargetPath))
+	}
+
+	switch p.Type {
+		case SSH_FXP_READ, SSH_FXP_WRITE: attrs = append(attrs, slog.Uint64("offset", p.Offset), slog.Uint64("len", p.Length))
+		case SSH_FXP_DATA, SSH_FXP_EXTENDED_REPLY, SSH_FXP_NAME: attrs = append(attrs, slog.Uint64("len", p.Length))
+		case SSH_FXP_STATUS: attrs = append(attrs, slog.String("status", statusCodeName(p.StatusCode)), slog.String("message", p.StatusMessage))
+	}
+
+	if p.Malformed {
+		attrs = append(attrs, slog.Bool("malformed", true))
+	}
+
+	l.Logger.LogAttrs(context.Background(), l.Level, "sftp packet", attrs...)
+}
@@ -0,0 +1,123 @@
+package bsftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tracedBytes is a packet as tracers see it, type and body.
+func tracedBytes(t *testing.T, p packetEncoder) []byte {
+	t.Helper()
+
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b[UINT32_COST:]
+}
+
+func TestDescribePacket(t *testing.T) {
+	write := tracedBytes(t, &sshFXPWritePacket{ID: 3, Handle: "1", Offset: 4096, Data: []byte("secret")})
+	for _, test := range []struct {
+		b        []byte
+		expected string
+	}{
+		{tracedBytes(t, &sshFXPInitPacket{Version: 3}), "received SSH_FXP_INIT version=3"},
+		{tracedBytes(t, &sshFXPOpenPacket{ID: 1, Filename: "/file", PFlags: SSH_FXF_READ}), `received SSH_FXP_OPEN id=1 path="/file"`},
+		{write, `received SSH_FXP_WRITE id=3 handle="1" offset=4096 len=6`},
+		{tracedBytes(t, &sshFXPRenamePacket{ID: 4, OldPath: "/a", NewPath: "/b"}), `received SSH_FXP_RENAME id=4 path="/a" target="/b"`},
+		{tracedBytes(t, sshFXPStatusPacket{ID: 5, StatusCode: SSH_FX_NO_SUCH_FILE, ErrorMessage: "missing"}), `received SSH_FXP_STATUS id=5 status=SSH_FX_NO_SUCH_FILE message="missing"`},
+		{tracedBytes(t, sshFXPDataPacket{ID: 6, Data: []byte("contents")}), "received SSH_FXP_DATA id=6 len=8"},
+		{write[:len(write)-10], "received SSH_FXP_WRITE id=3 offset=0 len=0 malformed"},
+		{[]byte{255, 0, 0, 0, 7}, "received SSH_FXP_255 id=7"},
+		{nil, "received SSH_FXP_0 id=0 malformed"},
+	} {
+		if description := DescribePacket(test.b).String(); description != test.expected {
+			t.Errorf("Expected %s, described %s", test.expected, description)
+		}
+	}
+}
+
+// Packets read back from a capture are those traced, less the contents of
+// reads and writes.
+func TestCaptureRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 123)
+	var b bytes.Buffer
+	capture := NewCaptureTracer(&b)
+
+	traced := []TracedPacket{
+		DescribePacket(tracedBytes(t, &sshFXPWritePacket{ID: 1, Handle: "1", Data: []byte("secret")})),
+		DescribePacket(tracedBytes(t, sshFXPDataPacket{ID: 2, Data: []byte("private")})),
+		DescribePacket(tracedBytes(t, &sshFXPStatPacket{ID: 3, Path: "/file"})),
+	}
+
+	for i := range traced {
+		traced[i].Time, traced[i].Direction = now, TraceDirection(i%2)
+		capture.Trace(traced[i])
+	}
+
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewCaptureReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range traced {
+		p, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		} else if p.String() != expected.String() || !p.Time.Equal(now) {
+			t.Fatalf("Expected %s at %v, read %s at %v", expected, now, p, p.Time)
+		} else if bytes.Contains(p.Packet, []byte("secret")) || bytes.Contains(p.Packet, []byte("private")) {
+			t.Fatalf("Expected the contents of %s redacted", p)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected EOF, found %v", err)
+	}
+}
+
+func TestCaptureReaderChecksHeader(t *testing.T) {
+	if _, err := NewCaptureReader(strings.NewReader("not a capture")); err != notCaptureError {
+		t.Fatalf("Expected %v, found %v", notCaptureError, err)
+	} else if _, err := NewCaptureReader(strings.NewReader(CAPTURE_MAGIC + "\x00\x00\x00\x02")); err != unsupportedCaptureError {
+		t.Fatalf("Expected %v, found %v", unsupportedCaptureError, err)
+	}
+}
+
+func TestSlogTracer(t *testing.T) {
+	var b bytes.Buffer
+	tracer := SlogTracer{Logger: slog.New(slog.NewJSONHandler(&b, nil)), Level: slog.LevelInfo}
+	p := DescribePacket(tracedBytes(t, &sshFXPReadPacket{ID: 9, Handle: "2", Offset: 100, Len: 50}))
+	p.Direction = TRACE_SENT
+	tracer.Trace(p)
+
+	var logged map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &logged); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"msg":       "sftp packet",
+		"direction": "sent",
+		"type":      "SSH_FXP_READ",
+		"id":        float64(9),
+		"handle":    "2",
+		"offset":    float64(100),
+		"len":       float64(50),
+	} {
+		if logged[key] != expected {
+			t.Errorf("Expected %s=%v logged, found %v", key, expected, logged[key])
+		}
+	}
+}
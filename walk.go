@@ -0,0 +1,58 @@
+package bsftp
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Walk walks the remote tree rooted at root like filepath.WalkDir, calling fn
+// for each file or directory in lexical order. Symbolic links are not
+// followed.
+func (c *Client) Walk(root string, fn fs.WalkDirFunc) error {
+	fi, err := c.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = c.walk(root, fs.FileInfoToDirEntry(fi), fn)
+	}
+
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Client) walk(name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+
+		return err
+	}
+
+	infos, err := c.ReadDir(name)
+	if err != nil {
+		// the directory itself is reported again, this time with the error
+		if err = fn(name, d, err); err != nil {
+			if err == fs.SkipDir {
+				err = nil
+			}
+
+			return err
+		}
+	}
+
+	for _, fi := range infos {
+		if err := c.walk(path.Join(name, fi.Name()), fs.FileInfoToDirEntry(fi), fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}